@@ -1,6 +1,7 @@
 package s2
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -15,3 +16,92 @@ type AuthController interface {
 	// request passes the auth check.
 	CustomAuth(r *http.Request) (bool, error)
 }
+
+// AuthContextController is a context-aware variant of `AuthController`.
+// `ctx` is cancelled if the client disconnects, and holds request-scoped
+// values such as the request ID.
+type AuthContextController interface {
+	// SecretKey is called when a request is made using AWS' auth V4 or V2. If
+	// the given access key exists, a non-nil secret key should be returned.
+	// Otherwise nil should be returned.
+	SecretKey(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, error)
+	// CustomAuth handles requests that are not using AWS' auth V4 or V2. You
+	// can use this to implement custom auth algorithms. Return true if the
+	// request passes the auth check.
+	CustomAuth(ctx context.Context, r *http.Request) (bool, error)
+}
+
+const (
+	// AuthMethodV4 is the auth method of requests authenticated via AWS'
+	// auth V4
+	AuthMethodV4 = "v4"
+	// AuthMethodV2 is the auth method of requests authenticated via AWS'
+	// auth V2
+	AuthMethodV2 = "v2"
+	// AuthMethodCustom is the auth method of requests authenticated via
+	// `CustomAuth`
+	AuthMethodCustom = "custom"
+)
+
+// Identity is the principal that a request was authenticated as
+type Identity struct {
+	// AccessKey is the access key the request was signed with. It's empty
+	// for custom auth.
+	AccessKey string
+	// Method is the auth method the request was authenticated with, e.g.
+	// `AuthMethodV4`
+	Method string
+	// Region is the region in the request's credential scope. It's only set
+	// for auth V4.
+	Region string
+	// Owner is the user the identity belongs to, if known
+	Owner *User
+	// Groups are the groups the identity belongs to, if any
+	Groups []string
+	// Claims are arbitrary attributes of the identity, e.g. those extracted
+	// by custom auth
+	Claims map[string]string
+	// SessionToken is the session token of the temporary credentials the
+	// request was signed with, if any
+	SessionToken string
+}
+
+// IdentityAuthController is an optional interface that an `AuthController`
+// or `AuthContextController` can implement to attach attributes to the
+// identities of authenticated requests. If implemented, its methods are
+// called instead of `SecretKey` and `CustomAuth`.
+type IdentityAuthController interface {
+	// SecretKeyIdentity is like `SecretKey`, but also returns the identity
+	// that the access key belongs to. The identity may be nil; its
+	// `AccessKey`, `Method` and `Region` are filled in by s2.
+	SecretKeyIdentity(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, *Identity, error)
+	// CustomAuthIdentity is like `CustomAuth`, but returns the identity of
+	// the request, or nil if it doesn't pass the auth check. Its `Method` is
+	// filled in by s2.
+	CustomAuthIdentity(ctx context.Context, r *http.Request) (*Identity, error)
+}
+
+// SessionAuthController is an optional interface that an `AuthController`
+// or `AuthContextController` can implement to support temporary
+// credentials. If implemented, `SessionSecretKey` is called instead of
+// `SecretKey` for requests made using AWS' auth V4 or V2 that include a
+// session token, via the `x-amz-security-token` header or
+// `X-Amz-Security-Token` query parameter. Otherwise, session tokens are
+// ignored. Requests without a session token are still looked up via
+// `SecretKey` or `SecretKeyIdentity`, which must not return the secret keys
+// of temporary credentials, so that they can't be used without their token.
+type SessionAuthController interface {
+	// SessionSecretKey is like `SecretKeyIdentity`, but is also passed the
+	// request's session token. If the token isn't valid for the access key,
+	// `InvalidTokenError` or `ExpiredTokenError` should be returned.
+	SessionSecretKey(ctx context.Context, r *http.Request, accessKey, sessionToken string, region *string) (*string, *Identity, error)
+}
+
+// sessionToken returns the session token of a request, or an empty string
+// if there is none
+func sessionToken(r *http.Request) string {
+	if token := r.Header.Get("x-amz-security-token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("X-Amz-Security-Token")
+}
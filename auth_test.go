@@ -0,0 +1,605 @@
+package s2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "us-east-1"
+)
+
+// authTestController is an `AuthController` with a single access key, which
+// counts how often secret keys are looked up
+type authTestController struct {
+	secretKey string
+	lookups   int
+}
+
+func (c *authTestController) SecretKey(r *http.Request, accessKey string, region *string) (*string, error) {
+	c.lookups++
+	if accessKey != testAccessKey {
+		return nil, nil
+	}
+	secretKey := c.secretKey
+	return &secretKey, nil
+}
+
+func (c *authTestController) CustomAuth(r *http.Request) (bool, error) {
+	return false, nil
+}
+
+// authTestObjectController is an `ObjectController` that serves empty
+// objects
+type authTestObjectController struct {
+	ObjectController
+}
+
+func (c authTestObjectController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	return &GetObjectResult{Content: bytes.NewReader(nil)}, nil
+}
+
+func (c authTestObjectController) PutObject(r *http.Request, bucket, key string, reader io.Reader) (*PutObjectResult, error) {
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		return nil, err
+	}
+	return &PutObjectResult{}, nil
+}
+
+// signV4 signs a request with an empty body using AWS' auth V4, signing the
+// host, `x-amz-content-sha256` and `x-amz-date` headers
+func signV4(r *http.Request, accessKey, secretKey string, timestamp time.Time) {
+	formattedTimestamp := formatAWSTimestamp(timestamp)
+	date := timestamp.Format("20060102")
+	payloadHash := sha256Hex("")
+	r.Header.Set("x-amz-content-sha256", payloadHash)
+	r.Header.Set("x-amz-date", formattedTimestamp)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		normURI(r.URL.Path),
+		normQuery(r.URL.Query()),
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", r.Host, payloadHash, formattedTimestamp),
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+	stringToSign := fmt.Sprintf(
+		"AWS4-HMAC-SHA256\n%s\n%s/%s/s3/aws4_request\n%x",
+		formattedTimestamp, date, testRegion, sha256.Sum256([]byte(canonicalRequest)),
+	)
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, testRegion)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s/%s/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%x",
+		accessKey, date, testRegion, hmacSHA256(signingKey, stringToSign),
+	))
+}
+
+// newAuthTestS2 creates an S2 instance that authenticates requests with
+// `controller`
+func newAuthTestS2(controller AuthController, cache *SigningKeyCache) *S2 {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Auth = controller
+	s.Object = authTestObjectController{}
+	s.SigningKeyCache = cache
+	return s
+}
+
+// serveSigned serves a GET object request signed with the given secret key,
+// returning its status code
+func serveSigned(handler http.Handler, secretKey string) int {
+	r := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(r, testAccessKey, secretKey, time.Now())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec.Code
+}
+
+// TestSigV4TestVectors verifies auth V4 signatures against requests from
+// AWS' SigV4 test suite, which are signed for the "service" service in
+// us-east-1 at 20150830T123600Z. The suite's path normalization cases are
+// omitted, since S3 doesn't normalize paths, or encode them twice.
+func TestSigV4TestVectors(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		headers       [][2]string
+		body          string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        "GET",
+			url:           "/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-empty-query-key",
+			method:        "GET",
+			url:           "/?Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        "GET",
+			url:           "/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "get-vanilla-query-order-value",
+			method:        "GET",
+			url:           "/?Param1=value2&Param1=Value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1",
+		},
+		{
+			name:          "get-vanilla-query-order-key",
+			method:        "GET",
+			url:           "/?Param1=value2&Param2=value1&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "fd5e0b719e1a46ad063e593d247bdce91c1382c44403404ff362bfb71e46a806",
+		},
+		{
+			name:          "get-vanilla-query-unreserved",
+			method:        "GET",
+			url:           "/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signedHeaders: "host;x-amz-date",
+			signature:     "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+		{
+			name:          "get-vanilla-utf8-query",
+			method:        "GET",
+			url:           "/?%E1%88%B4=bar",
+			signedHeaders: "host;x-amz-date",
+			signature:     "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04",
+		},
+		{
+			name:          "get-unreserved",
+			method:        "GET",
+			url:           "/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signedHeaders: "host;x-amz-date",
+			signature:     "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		},
+		{
+			name:          "get-utf8",
+			method:        "GET",
+			url:           "/%E1%88%B4",
+			signedHeaders: "host;x-amz-date",
+			signature:     "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+		{
+			name:          "get-space",
+			method:        "GET",
+			url:           "/example%20space/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+		{
+			name:          "get-header-key-duplicate",
+			method:        "GET",
+			url:           "/",
+			headers:       [][2]string{{"My-Header1", "value2"}, {"My-Header1", "value2"}, {"My-Header1", "value1"}},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "c9d5ea9f3f72853aea855b47ea873832890dbdd183b4468f858259531a5138ea",
+		},
+		{
+			name:          "get-header-value-order",
+			method:        "GET",
+			url:           "/",
+			headers:       [][2]string{{"My-Header1", "value4"}, {"My-Header1", "value1"}, {"My-Header1", "value3"}, {"My-Header1", "value2"}},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "08c7e5a9acfcfeb3ab6b2185e75ce8b1deb5e634ec47601a50643f830c755c01",
+		},
+		{
+			name:          "get-header-value-trim",
+			method:        "GET",
+			url:           "/",
+			headers:       [][2]string{{"My-Header1", " value1"}, {"My-Header2", ` "a   b   c"`}},
+			signedHeaders: "host;my-header1;my-header2;x-amz-date",
+			signature:     "acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736",
+		},
+		{
+			name:          "post-vanilla",
+			method:        "POST",
+			url:           "/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-vanilla-query",
+			method:        "POST",
+			url:           "/?Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11",
+		},
+		{
+			name:          "post-header-key-sort",
+			method:        "POST",
+			url:           "/",
+			headers:       [][2]string{{"My-Header1", "value1"}},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "c5410059b04c1ee005303aed430f6e6645f61f4dc9e1461ec8f8916fdf18852c",
+		},
+		{
+			name:          "post-header-value-case",
+			method:        "POST",
+			url:           "/",
+			headers:       [][2]string{{"My-Header1", "VALUE1"}},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "cdbc9802e29d2942e5e10b5bccfdd67c5f22c7c4e8ae67b53629efa58b974b7d",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        "POST",
+			url:           "/",
+			headers:       [][2]string{{"Content-Type", "application/x-www-form-urlencoded"}},
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:          "post-x-www-form-urlencoded-parameters",
+			method:        "POST",
+			url:           "/",
+			headers:       [][2]string{{"Content-Type", "application/x-www-form-urlencoded; charset=utf8"}},
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "http://example.amazonaws.com"+test.url, strings.NewReader(test.body))
+			r.Header.Set("X-Amz-Date", "20150830T123600Z")
+			for _, header := range test.headers {
+				r.Header.Add(header[0], header[1])
+			}
+
+			canonicalRequest := canonicalRequestV4(r, strings.Split(test.signedHeaders, ";"), sha256Hex(test.body))
+			stringToSign := stringToSignV4(canonicalRequest, "20150830T123600Z", "20150830", "us-east-1", "service")
+			signingKey := signingKeyV4(testSecretKey, "20150830", "us-east-1", "service")
+			if signature := fmt.Sprintf("%x", hmacSHA256(signingKey, stringToSign)); signature != test.signature {
+				t.Errorf("unexpected signature %s for canonical request:\n%s", signature, canonicalRequest)
+			}
+		})
+	}
+}
+
+// TestSigV2Examples verifies auth V2 signatures against the examples in AWS'
+// S3 documentation
+func TestSigV2Examples(t *testing.T) {
+	secretKey := "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		headers   [][2]string
+		signature string
+	}{
+		{
+			name:      "object get",
+			method:    "GET",
+			url:       "/johnsmith/photos/puppy.jpg",
+			headers:   [][2]string{{"Date", "Tue, 27 Mar 2007 19:36:42 +0000"}},
+			signature: "bWq2s1WEIj+Ydj0vQ697zp+IXMU=",
+		},
+		{
+			name:      "object put",
+			method:    "PUT",
+			url:       "/johnsmith/photos/puppy.jpg",
+			headers:   [][2]string{{"Content-Type", "image/jpeg"}, {"Date", "Tue, 27 Mar 2007 21:15:45 +0000"}},
+			signature: "MyyxeRY7whkBe+bq8fHCL/2kKUg=",
+		},
+		{
+			name:      "list",
+			method:    "GET",
+			url:       "/johnsmith/?prefix=photos&max-keys=50&marker=puppy",
+			headers:   [][2]string{{"Date", "Tue, 27 Mar 2007 19:42:41 +0000"}},
+			signature: "htDYFYduRNen8P9ZfE/s9SuKy0U=",
+		},
+		{
+			name:      "fetch",
+			method:    "GET",
+			url:       "/johnsmith/?acl",
+			headers:   [][2]string{{"Date", "Tue, 27 Mar 2007 19:44:46 +0000"}},
+			signature: "c2WLPFtWHVgbEmeEG93a4cG37dM=",
+		},
+		{
+			name:      "delete",
+			method:    "DELETE",
+			url:       "/johnsmith/photos/puppy.jpg",
+			headers:   [][2]string{{"Date", "Tue, 27 Mar 2007 21:20:27 +0000"}, {"x-amz-date", "Tue, 27 Mar 2007 21:20:26 +0000"}},
+			signature: "lx3byBScXR6KzyMaifNkardMwNk=",
+		},
+		{
+			name:   "upload",
+			method: "PUT",
+			url:    "/static.johnsmith.net/db-backup.dat.gz",
+			headers: [][2]string{
+				{"Date", "Tue, 27 Mar 2007 21:06:08 +0000"},
+				{"x-amz-acl", "public-read"},
+				{"content-type", "application/x-download"},
+				{"Content-MD5", "4gJE4saaMU4BqNR0kLY+lw=="},
+				{"X-Amz-Meta-ReviewedBy", "joe@johnsmith.net"},
+				{"X-Amz-Meta-ReviewedBy", "jane@johnsmith.net"},
+				{"X-Amz-Meta-FileChecksum", "0x02661779"},
+				{"X-Amz-Meta-ChecksumAlgorithm", "crc32"},
+			},
+			signature: "ilyl83RwaSoYIEdixDQcA4OnAnc=",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.url, nil)
+			for _, header := range test.headers {
+				r.Header.Add(header[0], header[1])
+			}
+
+			signatures := []string{}
+			for _, stringToSign := range stringsToSignV2(r) {
+				signature := base64.StdEncoding.EncodeToString(hmacSHA1([]byte(secretKey), stringToSign))
+				if signature == test.signature {
+					return
+				}
+				signatures = append(signatures, signature)
+			}
+			t.Errorf("unexpected signatures: %v", signatures)
+		})
+	}
+}
+
+func TestPayloadHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		payloadHash string
+		code        int
+	}{
+		{name: "signed", payloadHash: sha256Hex("content"), code: http.StatusOK},
+		{name: "unsigned", payloadHash: "UNSIGNED-PAYLOAD", code: http.StatusOK},
+		{name: "mismatched", payloadHash: sha256Hex("other content"), code: http.StatusBadRequest},
+		{name: "invalid", payloadHash: "abc", code: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newAuthTestS2(nil, nil)
+			r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader("content"))
+			r.Header.Set("Content-Length", "7")
+			r.Header.Set("x-amz-content-sha256", test.payloadHash)
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Errorf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSigningKeyCache(t *testing.T) {
+	controller := &authTestController{secretKey: testSecretKey}
+	cache := NewSigningKeyCache(10)
+	router := newAuthTestS2(controller, cache).Router()
+
+	for i := 0; i < 3; i++ {
+		if code := serveSigned(router, testSecretKey); code != http.StatusOK {
+			t.Fatalf("unexpected status code %d", code)
+		}
+	}
+	if controller.lookups != 1 {
+		t.Errorf("expected 1 secret key lookup, got %d", controller.lookups)
+	}
+
+	// rotate the secret key; the cached signing key is used until it's
+	// invalidated
+	controller.secretKey = "rotated"
+	if code := serveSigned(router, testSecretKey); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	cache.Invalidate(testAccessKey)
+	if cache.Len() != 0 {
+		t.Fatalf("unexpected cache length after invalidation: %d", cache.Len())
+	}
+	if code := serveSigned(router, testSecretKey); code != http.StatusForbidden {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	if code := serveSigned(router, "rotated"); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+}
+
+func TestSigningKeyCacheEviction(t *testing.T) {
+	cache := NewSigningKeyCache(2)
+	for _, accessKey := range []string{"a", "b", "c"} {
+		cache.add(signingKeyCacheKey{accessKey: accessKey}, []byte(accessKey), &Identity{})
+	}
+
+	if cache.Len() != 2 {
+		t.Fatalf("unexpected cache length: %d", cache.Len())
+	}
+	if _, _, ok := cache.get(signingKeyCacheKey{accessKey: "a"}); ok {
+		t.Errorf("expected least recently used key to be evicted")
+	}
+	if _, _, ok := cache.get(signingKeyCacheKey{accessKey: "c"}); !ok {
+		t.Errorf("expected most recently used key to be cached")
+	}
+}
+
+func TestSigningKeyCacheSessionToken(t *testing.T) {
+	controller := &authTestController{secretKey: testSecretKey}
+	cache := NewSigningKeyCache(10)
+	router := newAuthTestS2(controller, cache).Router()
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", "/bucket/key", nil)
+		r.Header.Set("x-amz-security-token", "token")
+		signV4(r, testAccessKey, testSecretKey, time.Now())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if controller.lookups != 2 || cache.Len() != 0 {
+		t.Errorf("expected requests with session tokens not to be cached")
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	s := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	r := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(r, testAccessKey, testSecretKey, time.Now().Add(-10*time.Minute))
+
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// a zero skew, e.g. from an `S2` literal, uses the default
+	s.ClockSkew = 0
+	rec = httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	s.ClockSkew = 5 * time.Minute
+	rec = httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "RequestTimeTooSkewed") {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	s := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	s.ReplayCache = NewMemoryReplayCache()
+	router := s.Router()
+
+	tests := []struct {
+		method string
+		codes  []int
+	}{
+		{method: "GET", codes: []int{http.StatusOK, http.StatusOK}},
+		{method: "PUT", codes: []int{http.StatusOK, http.StatusForbidden}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/bucket/key", nil)
+		r.Header.Set("Content-Length", "0")
+		signV4(r, testAccessKey, testSecretKey, time.Now())
+
+		for i, code := range test.codes {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+			if rec.Code != code {
+				t.Errorf("unexpected status code %d for %s request %d: %s", rec.Code, test.method, i, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestReplayCacheRetry(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	throttled := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	throttled.ReplayCache = cache
+	throttled.Limits = limitsTestController{accessKeyLimits: &Limits{RequestsPerSecond: 0.001, RequestBurst: 1}}
+	throttledRouter := throttled.Router()
+	unthrottled := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	unthrottled.ReplayCache = cache
+	unthrottledRouter := unthrottled.Router()
+
+	// use up the access key's burst
+	if code := serveSigned(throttledRouter, testSecretKey); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+
+	r := httptest.NewRequest("PUT", "/bucket/key", nil)
+	r.Header.Set("Content-Length", "0")
+	signV4(r, testAccessKey, testSecretKey, time.Now())
+
+	// requests rejected with SlowDown can be retried with the same
+	// signature, but only once they're accepted
+	steps := []struct {
+		router http.Handler
+		code   int
+	}{
+		{router: throttledRouter, code: http.StatusServiceUnavailable},
+		{router: unthrottledRouter, code: http.StatusOK},
+		{router: unthrottledRouter, code: http.StatusForbidden},
+	}
+	for i, step := range steps {
+		rec := httptest.NewRecorder()
+		step.router.ServeHTTP(rec, r)
+		if rec.Code != step.code {
+			t.Fatalf("unexpected status code %d for step %d: %s", rec.Code, i, rec.Body.String())
+		}
+	}
+}
+
+func TestMemoryReplayCacheExpiry(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	ctx := context.Background()
+
+	if seen, _ := cache.Seen(ctx, "expired", time.Now().Add(-time.Second)); seen {
+		t.Fatalf("unexpected seen signature")
+	}
+	if seen, _ := cache.Seen(ctx, "expired", time.Now().Add(time.Minute)); seen {
+		t.Errorf("expected expired signature not to be seen")
+	}
+	if seen, _ := cache.Seen(ctx, "expired", time.Now().Add(time.Minute)); !seen {
+		t.Errorf("expected signature to be seen")
+	}
+}
+
+func benchmarkAuthMiddleware(b *testing.B, cache *SigningKeyCache) {
+	router := newAuthTestS2(&authTestController{secretKey: testSecretKey}, cache).Router()
+	r := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(r, testAccessKey, testSecretKey, time.Now())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("unexpected status code %d", rec.Code)
+		}
+	}
+}
+
+func BenchmarkAuthMiddleware(b *testing.B) {
+	benchmarkAuthMiddleware(b, nil)
+}
+
+func BenchmarkAuthMiddlewareSigningKeyCache(b *testing.B) {
+	benchmarkAuthMiddleware(b, NewSigningKeyCache(1024))
+}
@@ -1,6 +1,7 @@
 package s2
 
 import (
+	"context"
 	"encoding/xml"
 	"net/http"
 	"time"
@@ -130,6 +131,50 @@ type BucketController interface {
 	SetBucketVersioning(r *http.Request, bucket, status string) error
 }
 
+// HeadBucketController is an optional interface that a `BucketController`
+// can implement to serve HEAD bucket requests cheaply. If it's not
+// implemented, HEAD bucket requests fall back to an empty `ListObjects`
+// call.
+type HeadBucketController interface {
+	// HeadBucket checks that a bucket exists and is accessible. It returns
+	// the bucket's region, or an empty string if regions are not supported.
+	HeadBucket(r *http.Request, bucket string) (string, error)
+}
+
+// BucketContextController is a context-aware variant of
+// `BucketController`
+type BucketContextController interface {
+	// GetLocation gets the location of a bucket
+	GetLocation(ctx context.Context, r *http.Request, bucket string) (string, error)
+
+	// ListObjects lists objects within a bucket
+	ListObjects(ctx context.Context, r *http.Request, bucket, prefix, marker, delimiter string, maxKeys int) (*ListObjectsResult, error)
+
+	// ListObjectVersions lists objects' versions within a bucket
+	ListObjectVersions(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, versionMarker string, delimiter string, maxKeys int) (*ListObjectVersionsResult, error)
+
+	// CreateBucket creates a bucket
+	CreateBucket(ctx context.Context, r *http.Request, bucket string) error
+
+	// DeleteBucket deletes a bucket
+	DeleteBucket(ctx context.Context, r *http.Request, bucket string) error
+
+	// GetBucketVersioning gets the state of versioning on the given bucket
+	GetBucketVersioning(ctx context.Context, r *http.Request, bucket string) (string, error)
+
+	// SetBucketVersioning sets the state of versioning on the given bucket
+	SetBucketVersioning(ctx context.Context, r *http.Request, bucket, status string) error
+}
+
+// HeadBucketContextController is a context-aware variant of
+// `HeadBucketController`, which a `BucketContextController` can optionally
+// implement
+type HeadBucketContextController interface {
+	// HeadBucket checks that a bucket exists and is accessible. It returns
+	// the bucket's region, or an empty string if regions are not supported.
+	HeadBucket(ctx context.Context, r *http.Request, bucket string) (string, error)
+}
+
 // unimplementedBucketController defines a controller that returns
 // `NotImplementedError` for all functionality
 type unimplementedBucketController struct{}
@@ -163,15 +208,16 @@ func (c unimplementedBucketController) SetBucketVersioning(r *http.Request, buck
 }
 
 type bucketHandler struct {
-	controller BucketController
-	logger     *logrus.Entry
+	controller     BucketContextController
+	headController HeadBucketContextController
+	logger         *logrus.Entry
 }
 
 func (h *bucketHandler) location(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	location, err := h.controller.GetLocation(r, bucket)
+	location, err := h.controller.GetLocation(r.Context(), r, bucket)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -199,7 +245,7 @@ func (h *bucketHandler) get(w http.ResponseWriter, r *http.Request) {
 	marker := r.FormValue("marker")
 	delimiter := r.FormValue("delimiter")
 
-	result, err := h.controller.ListObjects(r, bucket, prefix, marker, delimiter, maxKeys)
+	result, err := h.controller.ListObjects(r.Context(), r, bucket, prefix, marker, delimiter, maxKeys)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -257,11 +303,33 @@ func (h *bucketHandler) get(w http.ResponseWriter, r *http.Request) {
 	writeXML(h.logger, w, r, http.StatusOK, marshallable)
 }
 
+func (h *bucketHandler) head(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var region string
+	var err error
+	if h.headController != nil {
+		region, err = h.headController.HeadBucket(r.Context(), r, bucket)
+	} else {
+		_, err = h.controller.ListObjects(r.Context(), r, bucket, "", "", "", 0)
+	}
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	if region != "" {
+		w.Header().Set("x-amz-bucket-region", region)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *bucketHandler) put(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	if err := h.controller.CreateBucket(r, bucket); err != nil {
+	if err := h.controller.CreateBucket(r.Context(), r, bucket); err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
@@ -273,7 +341,7 @@ func (h *bucketHandler) del(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	if err := h.controller.DeleteBucket(r, bucket); err != nil {
+	if err := h.controller.DeleteBucket(r.Context(), r, bucket); err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
@@ -285,7 +353,7 @@ func (h *bucketHandler) versioning(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	status, err := h.controller.GetBucketVersioning(r, bucket)
+	status, err := h.controller.GetBucketVersioning(r.Context(), r, bucket)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -319,7 +387,7 @@ func (h *bucketHandler) setVersioning(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.controller.SetBucketVersioning(r, bucket, payload.Status)
+	err := h.controller.SetBucketVersioning(r.Context(), r, bucket, payload.Status)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -343,7 +411,7 @@ func (h *bucketHandler) listVersions(w http.ResponseWriter, r *http.Request) {
 	versionIDMarker := r.FormValue("version-id-marker")
 	delimiter := r.FormValue("delimiter")
 
-	result, err := h.controller.ListObjectVersions(r, bucket, prefix, keyMarker, versionIDMarker, delimiter, maxKeys)
+	result, err := h.controller.ListObjectVersions(r.Context(), r, bucket, prefix, keyMarker, versionIDMarker, delimiter, maxKeys)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
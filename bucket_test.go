@@ -0,0 +1,93 @@
+package s2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// headBucketTestController is a `BucketController` that serves a single
+// bucket named `bucket`, and counts the listings made of it
+type headBucketTestController struct {
+	unimplementedBucketController
+	lists int
+}
+
+func (c *headBucketTestController) ListObjects(r *http.Request, bucket, prefix, marker, delimiter string, maxKeys int) (*ListObjectsResult, error) {
+	if bucket != "bucket" {
+		return nil, NoSuchBucketError(r)
+	}
+	c.lists++
+	return &ListObjectsResult{}, nil
+}
+
+// headBucketTestRegionController is a `headBucketTestController` that also
+// implements `HeadBucketController`
+type headBucketTestRegionController struct {
+	headBucketTestController
+	heads int
+}
+
+func (c *headBucketTestRegionController) HeadBucket(r *http.Request, bucket string) (string, error) {
+	if bucket != "bucket" {
+		return "", NoSuchBucketError(r)
+	}
+	c.heads++
+	return "us-west-2", nil
+}
+
+func TestHeadBucket(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		region string
+	}{
+		{name: "existing", target: "/bucket", code: http.StatusOK, region: "us-west-2"},
+		{name: "trailing slash", target: "/bucket/", code: http.StatusOK, region: "us-west-2"},
+		{name: "missing", target: "/missing", code: http.StatusNotFound},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// without a `HeadBucketController`, an empty listing is used
+			controller := &headBucketTestController{}
+			s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+			s.Bucket = controller
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, httptest.NewRequest("HEAD", test.target, nil))
+			if rec.Code != test.code {
+				t.Errorf("expected status code %d without HeadBucket, got %d", test.code, rec.Code)
+			}
+			if v := rec.Header().Get("x-amz-bucket-region"); v != "" {
+				t.Errorf("unexpected region without HeadBucket: %q", v)
+			}
+			if test.code == http.StatusOK && controller.lists != 1 {
+				t.Errorf("expected one listing, got %d", controller.lists)
+			}
+
+			regionController := &headBucketTestRegionController{}
+			s = NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+			s.Bucket = regionController
+			rec = httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, httptest.NewRequest("HEAD", test.target, nil))
+			if rec.Code != test.code {
+				t.Errorf("expected status code %d with HeadBucket, got %d", test.code, rec.Code)
+			}
+			if v := rec.Header().Get("x-amz-bucket-region"); v != test.region {
+				t.Errorf("unexpected region with HeadBucket: %q", v)
+			}
+			if test.code == http.StatusOK && regionController.heads != 1 {
+				t.Errorf("expected one HeadBucket call, got %d", regionController.heads)
+			}
+			if regionController.lists != 0 {
+				t.Errorf("bucket was listed despite HeadBucket being implemented")
+			}
+		})
+	}
+}
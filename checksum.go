@@ -0,0 +1,105 @@
+package s2
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// ChecksumCRC32 specifies the CRC32 (IEEE) checksum algorithm
+	ChecksumCRC32 string = "CRC32"
+	// ChecksumCRC32C specifies the CRC32C (Castagnoli) checksum algorithm
+	ChecksumCRC32C string = "CRC32C"
+	// ChecksumCRC64NVME specifies the CRC64NVME checksum algorithm
+	ChecksumCRC64NVME string = "CRC64NVME"
+	// ChecksumSHA1 specifies the SHA1 checksum algorithm
+	ChecksumSHA1 string = "SHA1"
+	// ChecksumSHA256 specifies the SHA256 checksum algorithm
+	ChecksumSHA256 string = "SHA256"
+
+	// ChecksumTypeFullObject specifies that a checksum is calculated over
+	// the entire contents of an object
+	ChecksumTypeFullObject string = "FULL_OBJECT"
+	// ChecksumTypeComposite specifies that a checksum of a multipart object
+	// is calculated over the checksums of its parts
+	ChecksumTypeComposite string = "COMPOSITE"
+)
+
+// Checksum is an additional checksum of an object or part, as sent by
+// clients in `x-amz-checksum-*` headers
+type Checksum struct {
+	// Algorithm is the checksum algorithm, e.g. `ChecksumCRC32`
+	Algorithm string
+	// Value is the base64 encoding of the big-endian checksum. It may be
+	// empty when only the algorithm is known, e.g. when a multipart upload
+	// is initialized.
+	Value string
+	// Type is the checksum type, i.e. `ChecksumTypeFullObject` or
+	// `ChecksumTypeComposite`. An empty value is equivalent to
+	// `ChecksumTypeFullObject`.
+	Type string
+}
+
+// MultipartChecksum computes the checksum of an object created via a
+// multipart upload from the checksums of its parts. The parts must be in
+// order, and all have checksums of the given algorithm. Composite
+// checksums are the base64 encoding of the hash of the concatenated
+// (binary) part checksums, followed by a dash and the number of parts.
+// Full object checksums are only supported for CRC algorithms, and require
+// part sizes to be set.
+func MultipartChecksum(parts []*Part, algorithm, checksumType string) (*Checksum, error) {
+	if _, ok := checksumAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unknown checksum algorithm: %q", algorithm)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no parts specified")
+	}
+
+	partChecksums := make([][]byte, len(parts))
+	for i, part := range parts {
+		partChecksum, err := decodeChecksum(algorithm, part.Checksum(algorithm))
+		if err != nil {
+			return nil, fmt.Errorf("part %d has an invalid checksum: %v", part.PartNumber, err)
+		}
+		partChecksums[i] = partChecksum
+	}
+
+	switch checksumType {
+	case ChecksumTypeComposite:
+		hash := checksumAlgorithms[algorithm]()
+		for _, partChecksum := range partChecksums {
+			hash.Write(partChecksum)
+		}
+		return &Checksum{
+			Algorithm: algorithm,
+			Value:     fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(hash.Sum(nil)), len(parts)),
+			Type:      ChecksumTypeComposite,
+		}, nil
+	case ChecksumTypeFullObject:
+		poly, ok := crcPolynomials[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("full object checksums are not supported for %s", algorithm)
+		}
+		width := len(partChecksums[0]) * 8
+		var crc uint64
+		for i, partChecksum := range partChecksums {
+			// the CRC of an empty part is zero, so a non-zero CRC means
+			// the part's size wasn't set
+			if parts[i].Size == 0 && crcFromBytes(partChecksum) != 0 {
+				return nil, fmt.Errorf("part %d has no size, which full object checksums require", parts[i].PartNumber)
+			}
+			if i == 0 {
+				crc = crcFromBytes(partChecksum)
+			} else {
+				crc = crcCombine(poly, width, crc, crcFromBytes(partChecksum), int64(parts[i].Size))
+			}
+		}
+		return &Checksum{
+			Algorithm: algorithm,
+			Value:     base64.StdEncoding.EncodeToString(crcToBytes(crc, width/8)),
+			Type:      ChecksumTypeFullObject,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown checksum type: %q", checksumType)
+	}
+}
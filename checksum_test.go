@@ -0,0 +1,82 @@
+package s2
+
+import (
+	"encoding/base64"
+	"hash/crc64"
+	"testing"
+)
+
+func TestMultipartChecksum(t *testing.T) {
+	contents := [][]byte{[]byte("hello"), []byte(", "), []byte("world")}
+
+	for _, algorithm := range []string{ChecksumCRC32, ChecksumCRC32C, ChecksumCRC64NVME, ChecksumSHA1, ChecksumSHA256} {
+		t.Run(algorithm, func(t *testing.T) {
+			parts := []*Part{}
+			compositeHash := checksumAlgorithms[algorithm]()
+			for i, content := range contents {
+				part := &Part{PartNumber: i + 1, Size: uint64(len(content))}
+				part.SetChecksum(&Checksum{Algorithm: algorithm, Value: computeChecksum(algorithm, content)})
+				parts = append(parts, part)
+
+				partChecksum, err := decodeChecksum(algorithm, part.Checksum(algorithm))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				compositeHash.Write(partChecksum)
+			}
+
+			composite, err := MultipartChecksum(parts, algorithm, ChecksumTypeComposite)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := base64.StdEncoding.EncodeToString(compositeHash.Sum(nil)) + "-3"
+			if composite.Value != expected || composite.Type != ChecksumTypeComposite {
+				t.Errorf("unexpected composite checksum: %+v", composite)
+			}
+
+			fullObject, err := MultipartChecksum(parts, algorithm, ChecksumTypeFullObject)
+			if _, ok := crcPolynomials[algorithm]; !ok {
+				if err == nil {
+					t.Errorf("expected an error for a full object %s checksum", algorithm)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected = computeChecksum(algorithm, []byte("hello, world"))
+			if fullObject.Value != expected || fullObject.Type != ChecksumTypeFullObject {
+				t.Errorf("unexpected full object checksum: %+v (expected %q)", fullObject, expected)
+			}
+		})
+	}
+
+	if _, err := MultipartChecksum([]*Part{{PartNumber: 1}}, ChecksumCRC32, ChecksumTypeComposite); err == nil {
+		t.Errorf("expected an error for a part without a checksum")
+	}
+
+	// full object checksums can't be computed without part sizes, except
+	// for empty parts
+	unsized := []*Part{{PartNumber: 1}, {PartNumber: 2}}
+	unsized[0].SetChecksum(&Checksum{Algorithm: ChecksumCRC32, Value: computeChecksum(ChecksumCRC32, []byte("hello"))})
+	unsized[1].SetChecksum(&Checksum{Algorithm: ChecksumCRC32, Value: computeChecksum(ChecksumCRC32, []byte("world"))})
+	if _, err := MultipartChecksum(unsized, ChecksumCRC32, ChecksumTypeFullObject); err == nil {
+		t.Errorf("expected an error for parts without sizes")
+	}
+	unsized[1].SetChecksum(&Checksum{Algorithm: ChecksumCRC32, Value: computeChecksum(ChecksumCRC32, nil)})
+	unsized[0].Size = 5
+	fullObject, err := MultipartChecksum(unsized, ChecksumCRC32, ChecksumTypeFullObject)
+	if err != nil {
+		t.Fatalf("unexpected error for an empty part: %v", err)
+	}
+	if expected := computeChecksum(ChecksumCRC32, []byte("hello")); fullObject.Value != expected {
+		t.Errorf("unexpected full object checksum with an empty part: %+v (expected %q)", fullObject, expected)
+	}
+}
+
+func TestCRC64NVME(t *testing.T) {
+	// the standard check value for CRC-64/NVME
+	if crc := crc64.Checksum([]byte("123456789"), crc64NVMETable); crc != 0xae8b14860a799888 {
+		t.Errorf("unexpected checksum: %x", crc)
+	}
+}
@@ -0,0 +1,393 @@
+package s2
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// contextKey is the type of keys for request-scoped values that s2 stores
+// in request contexts
+type contextKey int
+
+const (
+	// requestIDContextKey is the context key for the request ID
+	requestIDContextKey contextKey = iota
+	// identityContextKey is the context key for the identity a request was
+	// authenticated as
+	identityContextKey
+	// chunkSignerContextKey is the context key for the auth V4 signing data
+	// used to verify the chunk signatures of multi-chunk uploads
+	chunkSignerContextKey
+	// errorCodeContextKey is the context key for where the S3 error code of
+	// a request's response is recorded, if it's being instrumented
+	errorCodeContextKey
+	// replayCheckContextKey is the context key for the signature of a
+	// mutating request, which is checked against the replay cache
+	replayCheckContextKey
+	// bodyVerifiedContextKey is the context key for whether a request's
+	// body was buffered and verified against its additional checksum by
+	// `bodyReadingMiddleware`
+	bodyVerifiedContextKey
+)
+
+// RequestIDFromContext returns the ID s2 assigned to the request a context
+// belongs to, or an empty string if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// AccessKeyFromContext returns the access key that the request a context
+// belongs to was authenticated with via AWS' auth V4 or V2, or an empty
+// string if there is none (e.g. if auth is disabled, or custom auth was
+// used.)
+func AccessKeyFromContext(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity != nil {
+		return identity.AccessKey
+	}
+	return ""
+}
+
+// IdentityFromContext returns the identity that the request a context
+// belongs to was authenticated as, or nil if there is none (e.g. if auth is
+// disabled.)
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityContextKey).(*Identity)
+	return identity
+}
+
+// IdentityFromRequest returns the identity that a request was authenticated
+// as, or nil if there is none (e.g. if auth is disabled.)
+func IdentityFromRequest(r *http.Request) *Identity {
+	return IdentityFromContext(r.Context())
+}
+
+// withErrorCodeRecorder returns a request whose context records the S3
+// error code of its response, along with where it's recorded. If the
+// request's context already records it, that's reused, so that every
+// middleware instrumenting the request sees the same code.
+func withErrorCodeRecorder(r *http.Request) (*http.Request, *string) {
+	if errorCode, ok := r.Context().Value(errorCodeContextKey).(*string); ok {
+		return r, errorCode
+	}
+	errorCode := new(string)
+	return withContextValue(r, errorCodeContextKey, errorCode), errorCode
+}
+
+// recordErrorCode records the S3 error code of a request's response, if the
+// request is being instrumented
+func recordErrorCode(r *http.Request, code string) {
+	if errorCode, ok := r.Context().Value(errorCodeContextKey).(*string); ok {
+		*errorCode = code
+	}
+}
+
+// withContextValue returns a shallow copy of a request with a value added to
+// its context
+func withContextValue(r *http.Request, key contextKey, value interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), key, value))
+}
+
+// requestWithContext returns a request with the given context, copying the
+// request only if its context differs
+func requestWithContext(r *http.Request, ctx context.Context) *http.Request {
+	if r.Context() == ctx {
+		return r
+	}
+	return r.WithContext(ctx)
+}
+
+// authControllerAdapter adapts an `AuthController` to an
+// `AuthContextController`, passing the context via the request
+type authControllerAdapter struct {
+	controller AuthController
+}
+
+func (a authControllerAdapter) SecretKey(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, error) {
+	return a.controller.SecretKey(requestWithContext(r, ctx), accessKey, region)
+}
+
+func (a authControllerAdapter) CustomAuth(ctx context.Context, r *http.Request) (bool, error) {
+	return a.controller.CustomAuth(requestWithContext(r, ctx))
+}
+
+// identityAuthControllerAdapter adapts an `AuthContextController` that
+// doesn't implement `IdentityAuthController`, returning identities without
+// any attributes
+type identityAuthControllerAdapter struct {
+	controller AuthContextController
+}
+
+func (a identityAuthControllerAdapter) SecretKeyIdentity(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, *Identity, error) {
+	secretKey, err := a.controller.SecretKey(ctx, r, accessKey, region)
+	return secretKey, nil, err
+}
+
+func (a identityAuthControllerAdapter) CustomAuthIdentity(ctx context.Context, r *http.Request) (*Identity, error) {
+	passed, err := a.controller.CustomAuth(ctx, r)
+	if err != nil || !passed {
+		return nil, err
+	}
+	return &Identity{}, nil
+}
+
+// serviceControllerAdapter adapts a `ServiceController` to a
+// `ServiceContextController`, passing the context via the request
+type serviceControllerAdapter struct {
+	controller ServiceController
+}
+
+func (a serviceControllerAdapter) ListBuckets(ctx context.Context, r *http.Request) (*ListBucketsResult, error) {
+	return a.controller.ListBuckets(requestWithContext(r, ctx))
+}
+
+// bucketControllerAdapter adapts a `BucketController` to a
+// `BucketContextController`, passing the context via the request
+type bucketControllerAdapter struct {
+	controller BucketController
+}
+
+func (a bucketControllerAdapter) GetLocation(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	return a.controller.GetLocation(requestWithContext(r, ctx), bucket)
+}
+
+func (a bucketControllerAdapter) ListObjects(ctx context.Context, r *http.Request, bucket, prefix, marker, delimiter string, maxKeys int) (*ListObjectsResult, error) {
+	return a.controller.ListObjects(requestWithContext(r, ctx), bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (a bucketControllerAdapter) ListObjectVersions(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, versionMarker string, delimiter string, maxKeys int) (*ListObjectVersionsResult, error) {
+	return a.controller.ListObjectVersions(requestWithContext(r, ctx), bucket, prefix, keyMarker, versionMarker, delimiter, maxKeys)
+}
+
+func (a bucketControllerAdapter) CreateBucket(ctx context.Context, r *http.Request, bucket string) error {
+	return a.controller.CreateBucket(requestWithContext(r, ctx), bucket)
+}
+
+func (a bucketControllerAdapter) DeleteBucket(ctx context.Context, r *http.Request, bucket string) error {
+	return a.controller.DeleteBucket(requestWithContext(r, ctx), bucket)
+}
+
+func (a bucketControllerAdapter) GetBucketVersioning(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	return a.controller.GetBucketVersioning(requestWithContext(r, ctx), bucket)
+}
+
+func (a bucketControllerAdapter) SetBucketVersioning(ctx context.Context, r *http.Request, bucket, status string) error {
+	return a.controller.SetBucketVersioning(requestWithContext(r, ctx), bucket, status)
+}
+
+// headBucketControllerAdapter adapts a `HeadBucketController` to a
+// `HeadBucketContextController`, passing the context via the request
+type headBucketControllerAdapter struct {
+	controller HeadBucketController
+}
+
+func (a headBucketControllerAdapter) HeadBucket(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	return a.controller.HeadBucket(requestWithContext(r, ctx), bucket)
+}
+
+// objectControllerAdapter adapts an `ObjectController` to an
+// `ObjectContextController`, passing the context via the request
+type objectControllerAdapter struct {
+	controller ObjectController
+}
+
+func (a objectControllerAdapter) GetObject(ctx context.Context, r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	return a.controller.GetObject(requestWithContext(r, ctx), bucket, key, version)
+}
+
+func (a objectControllerAdapter) CopyObject(ctx context.Context, r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(ObjectAttributesController); ok {
+		return c.CopyObjectWithAttributes(r, srcBucket, srcKey, getResult, destBucket, destKey, attrs)
+	}
+	version, err := a.controller.CopyObject(r, srcBucket, srcKey, getResult, destBucket, destKey)
+	if err != nil {
+		return nil, err
+	}
+	// the copy has the same contents as the source object
+	return &CopyObjectResult{ETag: getResult.ETag, Version: version}, nil
+}
+
+func (a objectControllerAdapter) PutObject(ctx context.Context, r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(ObjectAttributesController); ok {
+		return c.PutObjectWithAttributes(r, bucket, key, reader, attrs, precondition)
+	}
+	if precondition != nil {
+		return nil, NotImplementedError(r)
+	}
+	return a.controller.PutObject(r, bucket, key, reader)
+}
+
+func (a objectControllerAdapter) DeleteObject(ctx context.Context, r *http.Request, bucket, key, version string) (*DeleteObjectResult, error) {
+	return a.controller.DeleteObject(requestWithContext(r, ctx), bucket, key, version)
+}
+
+// objectPartsControllerAdapter adapts an `ObjectPartsController` to an
+// `ObjectPartsContextController`, passing the context via the request
+type objectPartsControllerAdapter struct {
+	controller ObjectPartsController
+}
+
+func (a objectPartsControllerAdapter) GetObjectParts(ctx context.Context, r *http.Request, bucket, key, version string) ([]*ObjectPart, error) {
+	return a.controller.GetObjectParts(requestWithContext(r, ctx), bucket, key, version)
+}
+
+// headObjectControllerAdapter adapts a `HeadObjectController` to a
+// `HeadObjectContextController`, passing the context via the request
+type headObjectControllerAdapter struct {
+	controller HeadObjectController
+}
+
+func (a headObjectControllerAdapter) HeadObject(ctx context.Context, r *http.Request, bucket, key, version string) (*HeadObjectResult, error) {
+	return a.controller.HeadObject(requestWithContext(r, ctx), bucket, key, version)
+}
+
+// multipartControllerAdapter adapts a `MultipartController` to a
+// `MultipartContextController`, passing the context via the request
+type multipartControllerAdapter struct {
+	controller MultipartController
+}
+
+func (a multipartControllerAdapter) ListMultipart(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*ListMultipartResult, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(ListMultipartPrefixController); ok {
+		return c.ListMultipartWithPrefix(r, bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
+	}
+	if prefix != "" || delimiter != "" {
+		return nil, NotImplementedError(r)
+	}
+	return a.controller.ListMultipart(r, bucket, keyMarker, uploadIDMarker, maxUploads)
+}
+
+func (a multipartControllerAdapter) InitMultipart(ctx context.Context, r *http.Request, bucket, key string, attrs *ObjectAttributes) (string, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(MultipartAttributesController); ok {
+		return c.InitMultipartWithAttributes(r, bucket, key, attrs)
+	}
+	return a.controller.InitMultipart(r, bucket, key)
+}
+
+func (a multipartControllerAdapter) AbortMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string) error {
+	return a.controller.AbortMultipart(requestWithContext(r, ctx), bucket, key, uploadID)
+}
+
+func (a multipartControllerAdapter) CompleteMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(MultipartAttributesController); ok {
+		return c.CompleteMultipartWithAttributes(r, bucket, key, uploadID, parts, checksum, precondition)
+	}
+	if precondition != nil {
+		return nil, NotImplementedError(r)
+	}
+	return a.controller.CompleteMultipart(r, bucket, key, uploadID, parts)
+}
+
+func (a multipartControllerAdapter) ListMultipartChunks(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error) {
+	return a.controller.ListMultipartChunks(requestWithContext(r, ctx), bucket, key, uploadID, partNumberMarker, maxParts)
+}
+
+func (a multipartControllerAdapter) UploadMultipartChunk(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader, checksum *Checksum) (string, error) {
+	r = requestWithContext(r, ctx)
+	if c, ok := a.controller.(MultipartAttributesController); ok {
+		return c.UploadMultipartChunkWithChecksum(r, bucket, key, uploadID, partNumber, reader, checksum)
+	}
+	return a.controller.UploadMultipartChunk(r, bucket, key, uploadID, partNumber, reader)
+}
+
+// authContextController returns the auth controller to use, adapting
+// `Auth` if `AuthContext` is not set. It returns nil if auth is disabled.
+func (h *S2) authContextController() AuthContextController {
+	if h.AuthContext != nil {
+		return h.AuthContext
+	}
+	if h.Auth != nil {
+		return authControllerAdapter{controller: h.Auth}
+	}
+	return nil
+}
+
+// identityAuthController returns the auth controller to use for
+// authenticating requests, adapting it if it doesn't implement
+// `IdentityAuthController`. It returns nil if auth is disabled.
+func (h *S2) identityAuthController() IdentityAuthController {
+	if h.AuthContext != nil {
+		if c, ok := h.AuthContext.(IdentityAuthController); ok {
+			return c
+		}
+	} else if c, ok := h.Auth.(IdentityAuthController); ok {
+		return c
+	}
+	if controller := h.authContextController(); controller != nil {
+		return identityAuthControllerAdapter{controller: controller}
+	}
+	return nil
+}
+
+// sessionAuthController returns the auth controller's
+// `SessionAuthController` implementation, or nil if it doesn't support
+// temporary credentials
+func (h *S2) sessionAuthController() SessionAuthController {
+	if h.AuthContext != nil {
+		c, _ := h.AuthContext.(SessionAuthController)
+		return c
+	}
+	c, _ := h.Auth.(SessionAuthController)
+	return c
+}
+
+// serviceContextController returns the service controller to use, adapting
+// `Service` if `ServiceContext` is not set
+func (h *S2) serviceContextController() ServiceContextController {
+	if h.ServiceContext != nil {
+		return h.ServiceContext
+	}
+	return serviceControllerAdapter{controller: h.Service}
+}
+
+// bucketContextControllers returns the bucket controller to use, adapting
+// `Bucket` if `BucketContext` is not set, along with its optional HEAD
+// bucket implementation (or nil)
+func (h *S2) bucketContextControllers() (BucketContextController, HeadBucketContextController) {
+	if h.BucketContext != nil {
+		headController, _ := h.BucketContext.(HeadBucketContextController)
+		return h.BucketContext, headController
+	}
+
+	var headController HeadBucketContextController
+	if c, ok := h.Bucket.(HeadBucketController); ok {
+		headController = headBucketControllerAdapter{controller: c}
+	}
+	return bucketControllerAdapter{controller: h.Bucket}, headController
+}
+
+// objectContextControllers returns the object controller to use, adapting
+// `Object` if `ObjectContext` is not set, along with its optional object
+// parts and HEAD object implementations (or nil)
+func (h *S2) objectContextControllers() (ObjectContextController, ObjectPartsContextController, HeadObjectContextController) {
+	if h.ObjectContext != nil {
+		partsController, _ := h.ObjectContext.(ObjectPartsContextController)
+		headController, _ := h.ObjectContext.(HeadObjectContextController)
+		return h.ObjectContext, partsController, headController
+	}
+
+	var partsController ObjectPartsContextController
+	if c, ok := h.Object.(ObjectPartsController); ok {
+		partsController = objectPartsControllerAdapter{controller: c}
+	}
+	var headController HeadObjectContextController
+	if c, ok := h.Object.(HeadObjectController); ok {
+		headController = headObjectControllerAdapter{controller: c}
+	}
+	return objectControllerAdapter{controller: h.Object}, partsController, headController
+}
+
+// multipartContextController returns the multipart controller to use,
+// adapting `Multipart` if `MultipartContext` is not set
+func (h *S2) multipartContextController() MultipartContextController {
+	if h.MultipartContext != nil {
+		return h.MultipartContext
+	}
+	return multipartControllerAdapter{controller: h.Multipart}
+}
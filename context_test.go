@@ -0,0 +1,264 @@
+package s2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// contextTestObjectController is an `ObjectContextController` that records
+// the request IDs it's called with
+type contextTestObjectController struct {
+	ObjectContextController
+	requestIDs []string
+}
+
+func (c *contextTestObjectController) GetObject(ctx context.Context, r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	c.requestIDs = append(c.requestIDs, RequestIDFromContext(ctx))
+	return &GetObjectResult{Content: bytes.NewReader([]byte("content"))}, nil
+}
+
+// contextTestAuthController is an `AuthContextController` that implements
+// `IdentityAuthController`, authenticating requests via a bearer token
+type contextTestAuthController struct {
+	AuthContextController
+}
+
+func (c contextTestAuthController) SecretKeyIdentity(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, *Identity, error) {
+	return nil, nil, nil
+}
+
+func (c contextTestAuthController) CustomAuthIdentity(ctx context.Context, r *http.Request) (*Identity, error) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		return nil, nil
+	}
+	return &Identity{
+		Owner:  &User{ID: "owner"},
+		Groups: []string{"admins"},
+		Claims: map[string]string{"token": token},
+	}, nil
+}
+
+// contextTestIdentityController is an `ObjectContextController` that
+// records the identities it's called with
+type contextTestIdentityController struct {
+	ObjectContextController
+	identities []*Identity
+}
+
+func (c *contextTestIdentityController) GetObject(ctx context.Context, r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	c.identities = append(c.identities, IdentityFromRequest(r))
+	return &GetObjectResult{Content: bytes.NewReader([]byte("content"))}, nil
+}
+
+// contextTestMultipartController is a `MultipartContextController` whose
+// `CompleteMultipart` blocks until its context is cancelled
+type contextTestMultipartController struct {
+	MultipartContextController
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (c *contextTestMultipartController) CompleteMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error) {
+	close(c.started)
+	<-ctx.Done()
+	close(c.cancelled)
+	return nil, ctx.Err()
+}
+
+func TestContextRequestID(t *testing.T) {
+	controller := &contextTestObjectController{}
+	s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+	s.ObjectContext = controller
+
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, httptest.NewRequest("GET", "/bucket/key", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(controller.requestIDs) != 1 || controller.requestIDs[0] == "" {
+		t.Fatalf("unexpected request IDs: %v", controller.requestIDs)
+	}
+}
+
+func TestContextIdentity(t *testing.T) {
+	controller := &contextTestIdentityController{}
+	s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+	s.AuthContext = contextTestAuthController{}
+	s.ObjectContext = controller
+
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, httptest.NewRequest("GET", "/bucket/key", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/bucket/key", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	s.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if len(controller.identities) != 1 {
+		t.Fatalf("unexpected identities: %v", controller.identities)
+	}
+	identity := controller.identities[0]
+	if identity.Method != AuthMethodCustom || identity.Owner.ID != "owner" || identity.Groups[0] != "admins" || identity.Claims["token"] != "Bearer token" {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+}
+
+func TestContextCompleteMultipartCancellation(t *testing.T) {
+	controller := &contextTestMultipartController{
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+	s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+	s.MultipartContext = controller
+
+	body := []byte("<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>etag</ETag></Part></CompleteMultipartUpload>")
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/bucket/key?uploadId=upload", bytes.NewReader(body)).WithContext(ctx)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	done := make(chan struct{})
+	go func() {
+		s.Router().ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	<-controller.started
+	cancel()
+
+	for _, ch := range []chan struct{}{controller.cancelled, done} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for cancellation")
+		}
+	}
+}
+
+// sharedIdentityAuthController is an `AuthContextController` that
+// implements `IdentityAuthController`, returning the same identity for
+// every request
+type sharedIdentityAuthController struct {
+	AuthContextController
+	identity *Identity
+}
+
+func (c sharedIdentityAuthController) SecretKeyIdentity(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, *Identity, error) {
+	secretKey := testSecretKey
+	return &secretKey, c.identity, nil
+}
+
+func (c sharedIdentityAuthController) CustomAuthIdentity(ctx context.Context, r *http.Request) (*Identity, error) {
+	return c.identity, nil
+}
+
+func TestContextSharedIdentity(t *testing.T) {
+	shared := &Identity{Owner: &User{ID: "owner"}}
+	controller := &contextTestIdentityController{}
+	s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+	s.AuthContext = sharedIdentityAuthController{identity: shared}
+	s.ObjectContext = controller
+	router := s.Router()
+
+	signed := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(signed, testAccessKey, testSecretKey, time.Now())
+	for _, req := range []*http.Request{signed, httptest.NewRequest("GET", "/bucket/key", nil)} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if shared.AccessKey != "" || shared.Method != "" || shared.Region != "" {
+		t.Errorf("the controller's identity was modified: %+v", shared)
+	}
+	if len(controller.identities) != 2 || controller.identities[0].Method != AuthMethodV4 || controller.identities[1].Method != AuthMethodCustom {
+		t.Fatalf("unexpected identities: %v", controller.identities)
+	}
+	if controller.identities[0].Owner.ID != "owner" {
+		t.Errorf("unexpected identity: %+v", controller.identities[0])
+	}
+}
+
+// TestOptionalAttributesController verifies that writes with preconditions
+// are rejected, rather than silently performed, for object controllers that
+// don't implement `ObjectAttributesController`
+func TestOptionalAttributesController(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Object = authTestObjectController{}
+	router := s.Router()
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		statusCode int
+	}{
+		{"unconditional", "", "", http.StatusOK},
+		{"if-none-match", "If-None-Match", "*", http.StatusNotImplemented},
+		{"if-match", "If-Match", `"etag"`, http.StatusNotImplemented},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/bucket/obj", bytes.NewReader([]byte("content")))
+			r.Header.Set("Content-Length", "7")
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+			if rec.Code != test.statusCode {
+				t.Errorf("expected status code %d, got %d: %s", test.statusCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadBodyAbandoned(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, timeout := range []bool{true, false} {
+		// a pipe blocks reads until it's written to or closed, like a body
+		// that a slow client never finishes sending
+		pr, pw := io.Pipe()
+		defer pw.Close()
+
+		r := httptest.NewRequest("PUT", "/bucket/key", pr).WithContext(ctx)
+		if !timeout {
+			s.readBodyTimeout = time.Minute
+			cancel()
+		}
+
+		body, err := s.readBody(r, 5)
+		if body != nil || err != nil {
+			t.Fatalf("unexpected result: %v, %v", body, err)
+		}
+		// the body was closed, and the reading goroutine has exited
+		if _, err := pw.Write([]byte("hello")); err != io.ErrClosedPipe {
+			t.Errorf("expected the body to be closed, got: %v", err)
+		}
+	}
+}
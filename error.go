@@ -77,6 +77,12 @@ func BucketAlreadyOwnedByYouError(r *http.Request) *Error {
 	return NewError(r, http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket you tried to create already exists, and you own it.")
 }
 
+// ConditionalRequestConflictError creates a new S3 error with a standard
+// ConditionalRequestConflict S3 code.
+func ConditionalRequestConflictError(r *http.Request) *Error {
+	return NewError(r, http.StatusConflict, "ConditionalRequestConflict", "A conflicting conditional operation is currently in progress against this resource. Please try again.")
+}
+
 // EntityTooLargeError creates a new S3 error with a standard EntityTooLarge
 // S3 code.
 func EntityTooLargeError(r *http.Request) *Error {
@@ -89,6 +95,12 @@ func EntityTooSmallError(r *http.Request) *Error {
 	return NewError(r, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size. Each part must be at least 5 MB in size, except the last part.")
 }
 
+// ExpiredTokenError creates a new S3 error with a standard ExpiredToken S3
+// code.
+func ExpiredTokenError(r *http.Request) *Error {
+	return NewError(r, http.StatusBadRequest, "ExpiredToken", "The provided token has expired.")
+}
+
 // IllegalVersioningConfigurationError creates a new S3 error with a standard
 // IllegalVersioningConfigurationException S3 code.
 func IllegalVersioningConfigurationError(r *http.Request) *Error {
@@ -117,6 +129,12 @@ func InvalidAccessKeyIDError(r *http.Request) *Error {
 	return NewError(r, http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records.")
 }
 
+// InvalidActionError creates a new STS error with a standard InvalidAction
+// code.
+func InvalidActionError(r *http.Request) *Error {
+	return NewError(r, http.StatusBadRequest, "InvalidAction", "The action or operation requested is invalid.")
+}
+
 // InvalidArgumentError creates a new S3 error with a standard InvalidArgument S3
 // code.
 func InvalidArgumentError(r *http.Request) *Error {
@@ -135,18 +153,36 @@ func InvalidPartError(r *http.Request) *Error {
 	return NewError(r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found. The part might not have been uploaded, or the specified entity tag might not have matched the part's entity tag.")
 }
 
+// InvalidPartNumberError creates a new S3 error with a standard
+// InvalidPartNumber S3 code.
+func InvalidPartNumberError(r *http.Request) *Error {
+	return NewError(r, http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "The requested partnumber is not satisfiable.")
+}
+
 // InvalidPartOrderError creates a new S3 error with a standard
 // InvalidPartOrder S3 code.
 func InvalidPartOrderError(w http.ResponseWriter, r *http.Request) *Error {
 	return NewError(r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order. Parts list must be specified in order by part number.")
 }
 
+// InvalidRangeError creates a new S3 error with a standard InvalidRange S3
+// code.
+func InvalidRangeError(r *http.Request) *Error {
+	return NewError(r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable.")
+}
+
 // InvalidRequestError creates a new S3 error with a standard
 // InvalidRequest S3 code.
 func InvalidRequestError(r *http.Request, message string) *Error {
 	return NewError(r, http.StatusBadRequest, "InvalidRequest", message)
 }
 
+// InvalidTokenError creates a new S3 error with a standard InvalidToken S3
+// code.
+func InvalidTokenError(r *http.Request) *Error {
+	return NewError(r, http.StatusBadRequest, "InvalidToken", "The provided token is malformed or otherwise invalid.")
+}
+
 // MalformedXMLError creates a new S3 error with a standard MalformedXML S3
 // code.
 func MalformedXMLError(r *http.Request) *Error {
@@ -206,6 +242,12 @@ func PreconditionFailedError(r *http.Request) *Error {
 	return NewError(r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
 }
 
+// QuotaExceededError creates a new S3 error with a QuotaExceeded code, as
+// used by S3-compatible stores for writes that exceed a storage quota.
+func QuotaExceededError(r *http.Request) *Error {
+	return NewError(r, http.StatusForbidden, "QuotaExceeded", "Your storage quota has been exceeded.")
+}
+
 // RequestTimeoutError creates a new S3 error with a standard RequestTimeout
 // S3 code.
 func RequestTimeoutError(r *http.Request) *Error {
@@ -223,3 +265,14 @@ func RequestTimeTooSkewedError(r *http.Request) *Error {
 func SignatureDoesNotMatchError(r *http.Request) *Error {
 	return NewError(r, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your auth credentials and signing method.")
 }
+
+// SlowDownError creates a new S3 error with a standard SlowDown S3 code.
+func SlowDownError(r *http.Request) *Error {
+	return NewError(r, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+}
+
+// ValidationError creates a new STS error with a standard ValidationError
+// code.
+func ValidationError(r *http.Request, message string) *Error {
+	return NewError(r, http.StatusBadRequest, "ValidationError", message)
+}
@@ -164,6 +164,26 @@ func (c *Controller) ListObjectVersions(r *http.Request, name, prefix, keyMarker
 	return &result, err
 }
 
+func (c *Controller) HeadBucket(r *http.Request, name string) (string, error) {
+	c.logger.Tracef("HeadBucket: %+v", name)
+
+	err := c.transaction(func(tx *gorm.DB) error {
+		_, err := models.GetBucket(tx, name)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return s2.NoSuchBucketError(r)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return models.Location, nil
+}
+
 func (c *Controller) CreateBucket(r *http.Request, name string) error {
 	c.logger.Tracef("CreateBucket: %+v", name)
 
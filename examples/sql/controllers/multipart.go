@@ -1,10 +1,10 @@
 package controllers
 
 import (
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/jinzhu/gorm"
 	"github.com/pachyderm/s2"
@@ -12,11 +12,33 @@ import (
 	"github.com/pachyderm/s2/examples/sql/util"
 )
 
+// highSentinel sorts after any key that shares its prefix, so that listing
+// after `prefix + highSentinel` skips every key starting with `prefix`
+const highSentinel = "\xff"
+
+// delimitedPrefix gets the common prefix that a key is listed under, or an
+// empty string if it doesn't contain the delimiter after the prefix
+func delimitedPrefix(key, prefix, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(key, prefix) {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delimiter)]
+}
+
 func (c *Controller) ListMultipart(r *http.Request, name, keyMarker, uploadIDMarker string, maxUploads int) (*s2.ListMultipartResult, error) {
-	c.logger.Tracef("ListMultipart: name=%+v, keyMarker=%+v, uploadIDMarker=%+v, maxUploads=%+v", name, keyMarker, uploadIDMarker, maxUploads)
+	return c.ListMultipartWithPrefix(r, name, "", keyMarker, uploadIDMarker, "", maxUploads)
+}
+
+func (c *Controller) ListMultipartWithPrefix(r *http.Request, name, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*s2.ListMultipartResult, error) {
+	c.logger.Tracef("ListMultipartWithPrefix: name=%+v, prefix=%+v, keyMarker=%+v, uploadIDMarker=%+v, delimiter=%+v, maxUploads=%+v", name, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
 
 	result := s2.ListMultipartResult{
-		Uploads: []*s2.Upload{},
+		Uploads:        []*s2.Upload{},
+		CommonPrefixes: []*s2.CommonPrefixes{},
 	}
 
 	err := c.transaction(func(tx *gorm.DB) error {
@@ -28,36 +50,69 @@ func (c *Controller) ListMultipart(r *http.Request, name, keyMarker, uploadIDMar
 			return err
 		}
 
-		uploads, err := models.ListUploads(tx, bucket.ID, keyMarker, uploadIDMarker, maxUploads+1)
-		if err != nil {
-			return err
+		// a key marker without an upload ID marker may be a common prefix
+		// from a previous page, whose uploads were all listed as part of it
+		if uploadIDMarker == "" {
+			if markerPrefix := delimitedPrefix(keyMarker, prefix, delimiter); markerPrefix != "" {
+				keyMarker = markerPrefix + highSentinel
+			}
 		}
 
-		for _, upload := range uploads {
-			if len(result.Uploads) >= maxUploads {
-				if maxUploads > 0 {
-					result.IsTruncated = true
-				}
-				break
+		for {
+			uploads, err := models.ListUploads(tx, bucket.ID, prefix, keyMarker, uploadIDMarker, maxUploads+1)
+			if err != nil {
+				return err
+			}
+			if len(uploads) == 0 {
+				return nil
 			}
 
-			result.Uploads = append(result.Uploads, &s2.Upload{
-				Key:          upload.Key,
-				UploadID:     upload.ID,
-				Initiator:    models.GlobalUser,
-				StorageClass: models.StorageClass,
-				Initiated:    models.Epoch,
-			})
-		}
+			for _, upload := range uploads {
+				if !strings.HasPrefix(upload.Key, prefix) {
+					return nil
+				}
+				if len(result.Uploads)+len(result.CommonPrefixes) >= maxUploads {
+					result.IsTruncated = maxUploads > 0
+					return nil
+				}
 
-		return nil
+				keyMarker = upload.Key
+				uploadIDMarker = upload.ID
+
+				if isDelimiterFiltered(upload.Key, prefix, delimiter) {
+					// skip the rest of the uploads under the common prefix
+					commonPrefix := delimitedPrefix(upload.Key, prefix, delimiter)
+					result.CommonPrefixes = append(result.CommonPrefixes, &s2.CommonPrefixes{
+						Prefix: commonPrefix,
+						Owner:  models.GlobalUser,
+					})
+					keyMarker = commonPrefix + highSentinel
+					uploadIDMarker = ""
+					break
+				}
+
+				result.Uploads = append(result.Uploads, &s2.Upload{
+					Key:          upload.Key,
+					UploadID:     upload.ID,
+					Initiator:    models.GlobalUser,
+					StorageClass: models.StorageClass,
+					Initiated:    models.Epoch,
+				})
+			}
+		}
 	})
 
 	return &result, err
 }
 
 func (c *Controller) InitMultipart(r *http.Request, name, key string) (string, error) {
-	c.logger.Tracef("InitMultipart: name=%+v, key=%+v", name, key)
+	return c.InitMultipartWithAttributes(r, name, key, nil)
+}
+
+// Initializes a multipart upload. `attrs` are stored with the upload, and
+// applied to the object once it's completed.
+func (c *Controller) InitMultipartWithAttributes(r *http.Request, name, key string, attrs *s2.ObjectAttributes) (string, error) {
+	c.logger.Tracef("InitMultipartWithAttributes: name=%+v, key=%+v, attrs=%+v", name, key, attrs)
 
 	result := ""
 
@@ -70,7 +125,7 @@ func (c *Controller) InitMultipart(r *http.Request, name, key string) (string, e
 			return err
 		}
 
-		upload, err := models.CreateUpload(tx, bucket.ID, key)
+		upload, err := models.CreateUpload(tx, bucket.ID, key, attrs)
 		if err != nil {
 			return err
 		}
@@ -107,7 +162,13 @@ func (c *Controller) AbortMultipart(r *http.Request, name, key, uploadID string)
 }
 
 func (c *Controller) CompleteMultipart(r *http.Request, name, key, uploadID string, parts []*s2.Part) (*s2.CompleteMultipartResult, error) {
-	c.logger.Tracef("CompleteMultipart: name=%+v, key=%+v, uploadID=%+v, parts=%+v", name, key, uploadID, parts)
+	return c.CompleteMultipartWithAttributes(r, name, key, uploadID, parts, nil, nil)
+}
+
+// Completes a multipart upload. Note that this doesn't support additional
+// checksums, so `checksum` is ignored.
+func (c *Controller) CompleteMultipartWithAttributes(r *http.Request, name, key, uploadID string, parts []*s2.Part, checksum *s2.Checksum, precondition *s2.WritePrecondition) (*s2.CompleteMultipartResult, error) {
+	c.logger.Tracef("CompleteMultipartWithAttributes: name=%+v, key=%+v, uploadID=%+v, parts=%+v, checksum=%+v, precondition=%+v", name, key, uploadID, parts, checksum, precondition)
 
 	result := s2.CompleteMultipartResult{
 		Location: models.Location,
@@ -122,17 +183,26 @@ func (c *Controller) CompleteMultipart(r *http.Request, name, key, uploadID stri
 			return err
 		}
 
-		_, err = models.GetUpload(tx, bucket.ID, key, uploadID)
+		upload, err := models.GetUpload(tx, bucket.ID, key, uploadID)
 		if err != nil {
 			if gorm.IsRecordNotFoundError(err) {
 				return s2.NoSuchUploadError(r)
 			}
 			return err
 		}
+		attrs, err := upload.GetAttributes()
+		if err != nil {
+			return err
+		}
+
+		if err := checkWritePrecondition(tx, r, bucket.ID, key, precondition); err != nil {
+			return err
+		}
 
 		content := []byte{}
 
-		for i, part := range parts {
+		// part ETags and sizes have already been validated by s2
+		for _, part := range parts {
 			uploadPart, err := models.GetUploadPart(tx, uploadID, part.PartNumber)
 			if err != nil {
 				if gorm.IsRecordNotFoundError(err) {
@@ -140,14 +210,6 @@ func (c *Controller) CompleteMultipart(r *http.Request, name, key, uploadID stri
 				}
 				return err
 			}
-			if fmt.Sprintf("\"%s\"", uploadPart.ETag) != part.ETag {
-				return s2.InvalidPartError(r)
-			}
-			// each part, except for the last, is expected to be at least 5mb in
-			// s3
-			if i < len(parts)-1 && len(uploadPart.Content) < 5*1024*1024 {
-				return s2.EntityTooSmallError(r)
-			}
 
 			content = append(content, uploadPart.Content...)
 		}
@@ -158,11 +220,21 @@ func (c *Controller) CompleteMultipart(r *http.Request, name, key, uploadID stri
 			result.Version = version
 		}
 
-		object, err := models.CreateObjectContent(tx, bucket.ID, key, version, content)
+		object, err := models.CreateObjectContent(tx, bucket.ID, key, version, content, attrs)
 		if err != nil {
 			return err
 		}
 
+		// use an S3-style multipart ETag rather than the MD5 hash of the
+		// entire object, so that clients can verify it
+		object.ETag, err = s2.MultipartETag(parts)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(&object).Error; err != nil {
+			return err
+		}
+
 		result.ETag = object.ETag
 
 		if err := models.DeleteUpload(tx, bucket.ID, key, uploadID); err != nil {
@@ -208,8 +280,10 @@ func (c *Controller) ListMultipartChunks(r *http.Request, name, key, uploadID st
 			}
 
 			result.Parts = append(result.Parts, &s2.Part{
-				PartNumber: uploadPart.Number,
-				ETag:       uploadPart.ETag,
+				PartNumber:   uploadPart.Number,
+				ETag:         uploadPart.ETag,
+				Size:         uint64(len(uploadPart.Content)),
+				LastModified: models.Epoch,
 			})
 		}
 
@@ -220,7 +294,13 @@ func (c *Controller) ListMultipartChunks(r *http.Request, name, key, uploadID st
 }
 
 func (c *Controller) UploadMultipartChunk(r *http.Request, name, key, uploadID string, partNumber int, reader io.Reader) (string, error) {
-	c.logger.Tracef("UploadMultipartChunk: name=%+v, key=%+v, uploadID=%+v partNumber=%+v", name, key, uploadID, partNumber)
+	return c.UploadMultipartChunkWithChecksum(r, name, key, uploadID, partNumber, reader, nil)
+}
+
+// Uploads a chunk of a multipart upload. Note that this doesn't support
+// additional checksums, so `checksum` is ignored.
+func (c *Controller) UploadMultipartChunkWithChecksum(r *http.Request, name, key, uploadID string, partNumber int, reader io.Reader, checksum *s2.Checksum) (string, error) {
+	c.logger.Tracef("UploadMultipartChunkWithChecksum: name=%+v, key=%+v, uploadID=%+v partNumber=%+v, checksum=%+v", name, key, uploadID, partNumber, checksum)
 
 	content, err := ioutil.ReadAll(reader)
 	if err != nil {
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/pachyderm/s2"
+	"github.com/pachyderm/s2/examples/sql/models"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestDB creates an in-memory database with a single bucket named
+// `bucket`
+func newTestDB(t *testing.T) (*gorm.DB, *models.Bucket) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	if err := models.Init(db); err != nil {
+		t.Fatalf("could not initialize database: %v", err)
+	}
+	bucket, err := models.CreateBucket(db, "bucket")
+	if err != nil {
+		t.Fatalf("could not create bucket: %v", err)
+	}
+	return db, bucket
+}
+
+func TestListMultipartPagination(t *testing.T) {
+	db, bucket := newTestDB(t)
+	defer db.Close()
+	for _, key := range []string{"o", "p/a/1", "p/a/2", "p/a/3", "p/a/4", "p/b", "p/b", "p/c/1", "p/c/2", "p/d", "q"} {
+		if _, err := models.CreateUpload(db, bucket.ID, key, nil); err != nil {
+			t.Fatalf("could not create upload: %v", err)
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := s2.NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Multipart = NewController(logrus.NewEntry(logger), db)
+	router := s.Router()
+
+	for maxUploads := 1; maxUploads <= 3; maxUploads++ {
+		var listed []string
+		keyMarker, uploadIDMarker := "", ""
+		for page := 0; ; page++ {
+			if page == 10 {
+				t.Fatalf("listing didn't terminate: %v", listed)
+			}
+
+			query := url.Values{"prefix": {"p/"}, "delimiter": {"/"}, "max-uploads": {strconv.Itoa(maxUploads)}, "key-marker": {keyMarker}, "upload-id-marker": {uploadIDMarker}}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/bucket?uploads&"+query.Encode(), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var result struct {
+				NextKeyMarker      string
+				NextUploadIDMarker string `xml:"NextUploadIdMarker"`
+				IsTruncated        bool
+				Uploads            []s2.Upload         `xml:"Upload"`
+				CommonPrefixes     []s2.CommonPrefixes `xml:"CommonPrefixes"`
+			}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("could not parse response: %v", err)
+			}
+			if len(result.Uploads)+len(result.CommonPrefixes) != maxUploads && result.IsTruncated {
+				t.Errorf("short truncated page with %d uploads per page: %s", maxUploads, rec.Body.String())
+			}
+			for _, upload := range result.Uploads {
+				listed = append(listed, upload.Key)
+			}
+			for _, commonPrefix := range result.CommonPrefixes {
+				listed = append(listed, commonPrefix.Prefix)
+			}
+			if !result.IsTruncated {
+				break
+			}
+			keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+		}
+
+		sort.Strings(listed)
+		expected := "p/a/,p/b,p/b,p/c/,p/d"
+		if strings.Join(listed, ",") != expected {
+			t.Errorf("unexpected listing with %d uploads per page: %v", maxUploads, listed)
+		}
+	}
+}
+
+func TestMultipartAttributes(t *testing.T) {
+	db, _ := newTestDB(t)
+	defer db.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := s2.NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	controller := NewController(logrus.NewEntry(logger), db)
+	s.Object = controller
+	s.Multipart = controller
+	router := s.Router()
+
+	serve := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code %d for %s %s: %s", rec.Code, method, target, rec.Body.String())
+		}
+		return rec
+	}
+
+	rec := serve("POST", "/bucket/key?uploads", "", map[string]string{
+		"Content-Type":     "text/csv",
+		"x-amz-meta-color": "red",
+		"x-amz-tagging":    "env=dev",
+	})
+	var initResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &initResult); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+
+	rec = serve("PUT", "/bucket/key?uploadId="+initResult.UploadID+"&partNumber=1", "a,b", nil)
+	etag := rec.Header().Get("ETag")
+
+	body := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>" + etag + "</ETag></Part></CompleteMultipartUpload>"
+	serve("POST", "/bucket/key?uploadId="+initResult.UploadID, body, nil)
+
+	for _, method := range []string{"GET", "HEAD"} {
+		rec = serve(method, "/bucket/key", "", nil)
+		if v := rec.Header().Get("Content-Type"); v != "text/csv" {
+			t.Errorf("unexpected content type for %s: %q", method, v)
+		}
+		if v := rec.Header().Get("x-amz-meta-color"); v != "red" {
+			t.Errorf("unexpected metadata for %s: %q", method, v)
+		}
+		if v := rec.Header().Get("x-amz-tagging-count"); v != "1" {
+			t.Errorf("unexpected tag count for %s: %q", method, v)
+		}
+	}
+}
@@ -20,40 +20,67 @@ func (c *Controller) GetObject(r *http.Request, name, key, version string) (*s2.
 	}
 
 	err := c.transaction(func(tx *gorm.DB) error {
-		bucket, err := models.GetBucket(tx, name)
+		object, versioned, err := c.getObject(tx, r, name, key, version)
 		if err != nil {
-			if gorm.IsRecordNotFoundError(err) {
-				return s2.NoSuchBucketError(r)
-			}
 			return err
 		}
 
-		var object models.Object
-		if bucket.Versioning == s2.VersioningEnabled && version != "" {
-			object, err = models.GetObject(tx, bucket.ID, key, version)
+		if versioned {
+			result.Version = object.Version
+		}
+
+		if object.DeleteMarker {
+			result.DeleteMarker = true
 		} else {
-			object, err = models.GetLatestObject(tx, bucket.ID, key)
+			result.ETag = object.ETag
+			result.Content = bytes.NewReader(object.Content)
+			result.Attributes, err = object.GetAttributes()
+			if err != nil {
+				return err
+			}
 		}
+
+		return nil
+	})
+
+	return &result, err
+}
+
+func (c *Controller) HeadObject(r *http.Request, name, key, version string) (*s2.HeadObjectResult, error) {
+	c.logger.Tracef("HeadObject: name=%+v, key=%+v, version=%+v", name, key, version)
+
+	result := s2.HeadObjectResult{
+		ModTime: models.Epoch,
+		Attributes: &s2.ObjectAttributes{
+			StorageClass: models.StorageClass,
+		},
+	}
+
+	err := c.transaction(func(tx *gorm.DB) error {
+		object, versioned, err := c.getObject(tx, r, name, key, version)
 		if err != nil {
-			if gorm.IsRecordNotFoundError(err) {
-				return s2.NoSuchKeyError(r)
-			}
 			return err
 		}
 
-		if bucket.Versioning == s2.VersioningEnabled {
+		if versioned {
 			result.Version = object.Version
 		}
 
 		if object.DeleteMarker {
-			if bucket.Versioning == s2.VersioningEnabled {
-				result.DeleteMarker = true
-			} else {
-				return s2.NoSuchKeyError(r)
-			}
+			result.DeleteMarker = true
 		} else {
 			result.ETag = object.ETag
-			result.Content = bytes.NewReader(object.Content)
+			result.Size = uint64(len(object.Content))
+			attrs, err := object.GetAttributes()
+			if err != nil {
+				return err
+			}
+			if attrs != nil {
+				result.Attributes = attrs
+				if result.Attributes.StorageClass == "" {
+					result.Attributes.StorageClass = models.StorageClass
+				}
+			}
 		}
 
 		return nil
@@ -63,14 +90,33 @@ func (c *Controller) GetObject(r *http.Request, name, key, version string) (*s2.
 }
 
 func (c *Controller) CopyObject(r *http.Request, srcBucket, srcKey string, obj *s2.GetObjectResult, destBucket, destKey string) (string, error) {
-	c.logger.Tracef("CopyObject: srcBucket=%+v, srcKey=%+v, obj=%+v, destBucket=%+v, destKey=%+v", srcBucket, srcKey, obj, destBucket, destKey)
-	version, _, err := c.putObject(r, destBucket, destKey, obj.Content)
-	return version, err
+	result, err := c.CopyObjectWithAttributes(r, srcBucket, srcKey, obj, destBucket, destKey, nil)
+	if err != nil {
+		return "", err
+	}
+	return result.Version, nil
+}
+
+func (c *Controller) CopyObjectWithAttributes(r *http.Request, srcBucket, srcKey string, obj *s2.GetObjectResult, destBucket, destKey string, attrs *s2.ObjectAttributes) (*s2.CopyObjectResult, error) {
+	c.logger.Tracef("CopyObjectWithAttributes: srcBucket=%+v, srcKey=%+v, obj=%+v, destBucket=%+v, destKey=%+v, attrs=%+v", srcBucket, srcKey, obj, destBucket, destKey, attrs)
+	version, etag, err := c.putObject(r, destBucket, destKey, obj.Content, attrs, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &s2.CopyObjectResult{
+		Version: version,
+		ETag:    etag,
+		ModTime: models.Epoch,
+	}, nil
 }
 
 func (c *Controller) PutObject(r *http.Request, name, key string, reader io.Reader) (*s2.PutObjectResult, error) {
-	c.logger.Tracef("PutObject: name=%+v, key=%+v", name, key)
-	version, etag, err := c.putObject(r, name, key, reader)
+	return c.PutObjectWithAttributes(r, name, key, reader, nil, nil)
+}
+
+func (c *Controller) PutObjectWithAttributes(r *http.Request, name, key string, reader io.Reader, attrs *s2.ObjectAttributes, precondition *s2.WritePrecondition) (*s2.PutObjectResult, error) {
+	c.logger.Tracef("PutObjectWithAttributes: name=%+v, key=%+v, attrs=%+v, precondition=%+v", name, key, attrs, precondition)
+	version, etag, err := c.putObject(r, name, key, reader, attrs, precondition)
 	if err != nil {
 		return nil, err
 	}
@@ -121,6 +167,7 @@ func (c *Controller) DeleteObject(r *http.Request, name, key, version string) (*
 			object.DeleteMarker = true
 			object.ETag = ""
 			object.Content = nil
+			object.Attributes = nil
 			if err = tx.Save(&object).Error; err != nil {
 				return err
 			}
@@ -134,7 +181,7 @@ func (c *Controller) DeleteObject(r *http.Request, name, key, version string) (*
 	return &result, err
 }
 
-func (c *Controller) putObject(r *http.Request, name, key string, reader io.Reader) (string, string, error) {
+func (c *Controller) putObject(r *http.Request, name, key string, reader io.Reader, attrs *s2.ObjectAttributes, precondition *s2.WritePrecondition) (string, string, error) {
 	bytes, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return "", "", err
@@ -152,8 +199,12 @@ func (c *Controller) putObject(r *http.Request, name, key string, reader io.Read
 			return err
 		}
 
+		if err := checkWritePrecondition(tx, r, bucket.ID, key, precondition); err != nil {
+			return err
+		}
+
 		if bucket.Versioning == s2.VersioningEnabled {
-			object, err := models.CreateObjectContent(tx, bucket.ID, key, util.RandomString(10), bytes)
+			object, err := models.CreateObjectContent(tx, bucket.ID, key, util.RandomString(10), bytes, attrs)
 			if err != nil {
 				return err
 			}
@@ -171,7 +222,7 @@ func (c *Controller) putObject(r *http.Request, name, key string, reader io.Read
 				}
 			}
 
-			object, err = models.CreateObjectContent(tx, bucket.ID, key, "null", bytes)
+			object, err = models.CreateObjectContent(tx, bucket.ID, key, "null", bytes, attrs)
 			if err != nil {
 				return err
 			}
@@ -183,3 +234,54 @@ func (c *Controller) putObject(r *http.Request, name, key string, reader io.Read
 
 	return version, etag, err
 }
+
+// getObject looks up an object for GetObject and HeadObject calls. Along with
+// the object, it returns whether versioning is enabled on its bucket. Delete
+// markers are only returned when versioning is enabled.
+func (c *Controller) getObject(tx *gorm.DB, r *http.Request, name, key, version string) (models.Object, bool, error) {
+	bucket, err := models.GetBucket(tx, name)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return models.Object{}, false, s2.NoSuchBucketError(r)
+		}
+		return models.Object{}, false, err
+	}
+
+	versioned := bucket.Versioning == s2.VersioningEnabled
+
+	var object models.Object
+	if versioned && version != "" {
+		object, err = models.GetObject(tx, bucket.ID, key, version)
+	} else {
+		object, err = models.GetLatestObject(tx, bucket.ID, key)
+	}
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return models.Object{}, false, s2.NoSuchKeyError(r)
+		}
+		return models.Object{}, false, err
+	}
+
+	if object.DeleteMarker && !versioned {
+		return models.Object{}, false, s2.NoSuchKeyError(r)
+	}
+
+	return object, versioned, nil
+}
+
+// checkWritePrecondition checks a write precondition against the latest
+// version of an object. It should be called in the same transaction as the
+// write.
+func checkWritePrecondition(tx *gorm.DB, r *http.Request, bucketID uint, key string, precondition *s2.WritePrecondition) error {
+	if precondition == nil {
+		return nil
+	}
+
+	object, err := models.GetLatestObject(tx, bucketID, key)
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	exists := err == nil && !object.DeleteMarker
+	return precondition.Check(r, exists, object.ETag)
+}
@@ -34,6 +34,7 @@ func main() {
 	s3.Bucket = controller
 	s3.Object = controller
 	s3.Multipart = controller
+	s3.ValidateMultipartParts = true
 
 	router := s3.Router()
 
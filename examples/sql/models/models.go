@@ -5,6 +5,7 @@ package models
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -60,8 +61,35 @@ type Object struct {
 
 	DeleteMarker bool `gorm:"not null"`
 
-	ETag    string
-	Content []byte
+	ETag       string
+	Content    []byte
+	Attributes []byte
+}
+
+// encodeAttributes serializes object attributes for storage
+func encodeAttributes(attrs *s2.ObjectAttributes) ([]byte, error) {
+	if attrs == nil {
+		return nil, nil
+	}
+	return json.Marshal(attrs)
+}
+
+// decodeAttributes deserializes stored object attributes, returning nil if
+// none were stored
+func decodeAttributes(encoded []byte) (*s2.ObjectAttributes, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	var attrs s2.ObjectAttributes
+	if err := json.Unmarshal(encoded, &attrs); err != nil {
+		return nil, err
+	}
+	return &attrs, nil
+}
+
+// GetAttributes returns the object's attributes, or nil if it has none
+func (o Object) GetAttributes() (*s2.ObjectAttributes, error) {
+	return decodeAttributes(o.Attributes)
 }
 
 func GetObject(db *gorm.DB, bucketID uint, key, version string) (Object, error) {
@@ -91,7 +119,11 @@ func ListObjects(db *gorm.DB, bucketID uint, keyMarker, versionMarker string, li
 	return objects, q.Error
 }
 
-func CreateObjectContent(db *gorm.DB, bucketID uint, key, version string, content []byte) (Object, error) {
+func CreateObjectContent(db *gorm.DB, bucketID uint, key, version string, content []byte, attrs *s2.ObjectAttributes) (Object, error) {
+	encodedAttrs, err := encodeAttributes(attrs)
+	if err != nil {
+		return Object{}, err
+	}
 	object := Object{
 		BucketID:     bucketID,
 		Key:          key,
@@ -99,8 +131,9 @@ func CreateObjectContent(db *gorm.DB, bucketID uint, key, version string, conten
 		DeleteMarker: false,
 		ETag:         fmt.Sprintf("%x", md5.Sum(content)),
 		Content:      content,
+		Attributes:   encodedAttrs,
 	}
-	err := db.Create(&object).Error
+	err = db.Create(&object).Error
 	return object, err
 }
 
@@ -119,36 +152,50 @@ type Upload struct {
 	ID       string `gorm:"primary_key"`
 	BucketID uint   `gorm:"not null"`
 	Key      string `gorm:"not null,index:idx_upload_key"`
+
+	// Attributes are the attributes the object should have once the upload
+	// is completed
+	Attributes []byte
 }
 
-func CreateUpload(db *gorm.DB, bucketID uint, key string) (Upload, error) {
+func CreateUpload(db *gorm.DB, bucketID uint, key string, attrs *s2.ObjectAttributes) (Upload, error) {
+	encodedAttrs, err := encodeAttributes(attrs)
+	if err != nil {
+		return Upload{}, err
+	}
 	upload := Upload{
-		ID:       util.RandomString(10),
-		BucketID: bucketID,
-		Key:      key,
+		ID:         util.RandomString(10),
+		BucketID:   bucketID,
+		Key:        key,
+		Attributes: encodedAttrs,
 	}
-	err := db.Create(&upload).Error
+	err = db.Create(&upload).Error
 	return upload, err
 }
 
+// GetAttributes returns the attributes the upload's object should have, or
+// nil if it has none
+func (u Upload) GetAttributes() (*s2.ObjectAttributes, error) {
+	return decodeAttributes(u.Attributes)
+}
+
 func GetUpload(db *gorm.DB, bucketID uint, key, id string) (Upload, error) {
 	var upload Upload
 	err := db.Where("bucket_id = ? AND key = ? AND id = ?", bucketID, key, id).First(&upload).Error
 	return upload, err
 }
 
-func ListUploads(db *gorm.DB, bucketID uint, keyMarker string, idMarker string, limit int) ([]Upload, error) {
+func ListUploads(db *gorm.DB, bucketID uint, prefix, keyMarker, idMarker string, limit int) ([]Upload, error) {
 	var parts []Upload
-	q := db.Limit(limit).Order("bucket_id, key, id")
+	q := db.Limit(limit).Order("bucket_id, key, id").Where("bucket_id = ? AND key >= ?", bucketID, prefix)
 
-	if keyMarker == "" && idMarker == "" {
-		q = q.Where("bucket_id = ?", bucketID).Find(&parts)
-	} else if idMarker == "" {
-		q = q.Where("bucket_id = ? AND key > ?", bucketID, keyMarker).Find(&parts)
-	} else {
-		q = q.Where("bucket_id = ? AND key >= ? AND id > ?", bucketID, keyMarker, idMarker).Find(&parts)
+	if idMarker != "" {
+		q = q.Where("key > ? OR (key = ? AND id > ?)", keyMarker, keyMarker, idMarker)
+	} else if keyMarker != "" {
+		q = q.Where("key > ?", keyMarker)
 	}
 
+	q = q.Find(&parts)
 	return parts, q.Error
 }
 
@@ -1,6 +1,6 @@
 module github.com/pachyderm/s2
 
-go 1.12
+go 1.15
 
 require (
 	github.com/gofrs/uuid v3.2.0+incompatible
@@ -8,5 +8,9 @@ require (
 	github.com/jinzhu/gorm v1.9.12 // indirect
 	github.com/minio/minio-go/v6 v6.0.55 // indirect
 	github.com/pachyderm/s2/examples/sql v0.0.0-20200528231500-590b33e3c716 // indirect
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.5.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 )
@@ -0,0 +1,303 @@
+package s2
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Limits are the throttling limits of an access key or bucket. Zero values
+// are unlimited.
+type Limits struct {
+	// RequestsPerSecond is the sustained rate of requests
+	RequestsPerSecond float64
+	// RequestBurst is the number of requests that can be made at once, in
+	// excess of the sustained rate. This defaults to a second's worth of
+	// requests.
+	RequestBurst int
+	// BytesPerSecond is the sustained rate of bytes transferred, counting
+	// both request and response bodies. Requests are admitted while the
+	// budget isn't exhausted, and then charged for the bytes they transfer.
+	BytesPerSecond float64
+	// ByteBurst is the number of bytes that can be transferred at once, in
+	// excess of the sustained rate. This defaults to a second's worth of
+	// bytes.
+	ByteBurst int64
+	// MaxInFlight is the maximum number of concurrent requests
+	MaxInFlight int
+}
+
+// LimitsController is an interface for looking up throttling limits, e.g.
+// from a database. Requests that exceed the limits of their access key or
+// bucket are rejected with `SlowDownError`.
+type LimitsController interface {
+	// AccessKeyLimits returns the limits of an access key, or nil if it's
+	// unlimited. It's only called for requests authenticated via AWS' auth
+	// V4 or V2.
+	AccessKeyLimits(ctx context.Context, r *http.Request, accessKey string) (*Limits, error)
+	// BucketLimits returns the limits of a bucket, or nil if it's
+	// unlimited. It's only called for requests to buckets or objects.
+	BucketLimits(ctx context.Context, r *http.Request, bucket string) (*Limits, error)
+}
+
+// tokenBucket is a token bucket rate limiter. Its balance may go negative
+// when charged after the fact, in which case it has to be refilled before
+// more requests are admitted.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accumulated since the last refill, up to `burst`
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// fullAt returns when the bucket will have refilled to `burst`
+func (b *tokenBucket) fullAt(rate, burst float64) time.Time {
+	if b.last.IsZero() || b.tokens >= burst {
+		return b.last
+	}
+	return b.last.Add(time.Duration((burst - b.tokens) / rate * float64(time.Second)))
+}
+
+// requestBurst returns the request burst of the limits, applying its default
+func (limits *Limits) requestBurst() float64 {
+	if limits.RequestBurst <= 0 {
+		return math.Max(1, math.Ceil(limits.RequestsPerSecond))
+	}
+	return float64(limits.RequestBurst)
+}
+
+// byteBurst returns the byte burst of the limits, applying its default
+func (limits *Limits) byteBurst() float64 {
+	if limits.ByteBurst <= 0 {
+		return limits.BytesPerSecond
+	}
+	return float64(limits.ByteBurst)
+}
+
+// limiterState is the throttling state of an access key or bucket
+type limiterState struct {
+	requests tokenBucket
+	bytes    tokenBucket
+	inFlight int
+	// idleAt is when the state's buckets will have refilled, after which it
+	// can be evicted if no requests are in flight
+	idleAt time.Time
+}
+
+// updateIdleAt updates when the state's buckets will have refilled, after
+// they've been charged under the given limits
+func (s *limiterState) updateIdleAt(limits *Limits) {
+	s.idleAt = time.Time{}
+	if limits.RequestsPerSecond > 0 {
+		if t := s.requests.fullAt(limits.RequestsPerSecond, limits.requestBurst()); t.After(s.idleAt) {
+			s.idleAt = t
+		}
+	}
+	if limits.BytesPerSecond > 0 {
+		if t := s.bytes.fullAt(limits.BytesPerSecond, limits.byteBurst()); t.After(s.idleAt) {
+			s.idleAt = t
+		}
+	}
+}
+
+// limiter tracks the throttling state of access keys and buckets. Idle
+// states, which are indistinguishable from new ones, are periodically
+// evicted so that the states of one-off access keys and buckets don't
+// accumulate.
+type limiter struct {
+	mu      sync.Mutex
+	states  map[string]*limiterState
+	nextGC  time.Time
+	gcEvery time.Duration
+}
+
+// acquire admits a request under the given limits, returning false if
+// they're exceeded. Admitted requests must be released.
+func (l *limiter) acquire(name string, limits *Limits, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.After(l.nextGC) {
+		for n, state := range l.states {
+			if state.inFlight == 0 && !now.Before(state.idleAt) {
+				delete(l.states, n)
+			}
+		}
+		l.nextGC = now.Add(l.gcEvery)
+	}
+
+	state, ok := l.states[name]
+	if !ok {
+		state = &limiterState{}
+		l.states[name] = state
+	}
+
+	if limits.MaxInFlight > 0 && state.inFlight >= limits.MaxInFlight {
+		return false
+	}
+	if limits.RequestsPerSecond > 0 {
+		state.requests.refill(now, limits.RequestsPerSecond, limits.requestBurst())
+		if state.requests.tokens < 1 {
+			return false
+		}
+	}
+	if limits.BytesPerSecond > 0 {
+		state.bytes.refill(now, limits.BytesPerSecond, limits.byteBurst())
+		if state.bytes.tokens <= 0 {
+			return false
+		}
+	}
+
+	if limits.RequestsPerSecond > 0 {
+		state.requests.tokens--
+	}
+	state.inFlight++
+	state.updateIdleAt(limits)
+	return true
+}
+
+// release releases a request admitted by `acquire`, charging it for the
+// bytes it transferred
+func (l *limiter) release(name string, limits *Limits, bytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.states[name]
+	state.inFlight--
+	if limits.BytesPerSecond > 0 {
+		state.bytes.tokens -= float64(bytes)
+	}
+	state.updateIdleAt(limits)
+}
+
+// cancel releases a request admitted by `acquire` that wasn't served,
+// refunding its request token
+func (l *limiter) cancel(name string, limits *Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.states[name]
+	state.inFlight--
+	if limits.RequestsPerSecond > 0 {
+		state.requests.tokens++
+	}
+	state.updateIdleAt(limits)
+}
+
+// countingResponseWriter is an `http.ResponseWriter` that counts the bytes
+// written to the response body, and records the response's status code
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+	status  int
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// statusCode returns the response's status code, which defaults to 200 if
+// nothing was written
+func (w *countingResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// newLimiter creates a new limiter with no throttling state
+func newLimiter() *limiter {
+	return &limiter{
+		states:  map[string]*limiterState{},
+		gcEvery: time.Minute,
+	}
+}
+
+// limitsMiddleware creates a middleware for throttling requests according to
+// the limits of their access key and bucket. The throttling state is kept in
+// `l`, since mux creates middleware handlers for every request.
+func (h *S2) limitsMiddleware(l *limiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return h.limitsHandler(l, next)
+	}
+}
+
+// limitsHandler creates a middleware handler that throttles requests
+// according to the limits of their access key and bucket
+func (h *S2) limitsHandler(l *limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type acquired struct {
+			name   string
+			limits *Limits
+		}
+		var toAcquire []acquired
+
+		if accessKey := AccessKeyFromContext(r.Context()); accessKey != "" {
+			limits, err := h.Limits.AccessKeyLimits(r.Context(), r, accessKey)
+			if err != nil {
+				WriteError(h.logger, w, r, err)
+				return
+			}
+			if limits != nil {
+				toAcquire = append(toAcquire, acquired{name: "accessKey:" + accessKey, limits: limits})
+			}
+		}
+		if bucket := mux.Vars(r)["bucket"]; bucket != "" {
+			limits, err := h.Limits.BucketLimits(r.Context(), r, bucket)
+			if err != nil {
+				WriteError(h.logger, w, r, err)
+				return
+			}
+			if limits != nil {
+				toAcquire = append(toAcquire, acquired{name: "bucket:" + bucket, limits: limits})
+			}
+		}
+
+		now := time.Now()
+		for i, a := range toAcquire {
+			if !l.acquire(a.name, a.limits, now) {
+				for _, a := range toAcquire[:i] {
+					l.cancel(a.name, a.limits)
+				}
+				WriteError(h.logger, w, r, SlowDownError(r))
+				return
+			}
+		}
+
+		cw := &countingResponseWriter{ResponseWriter: w}
+		defer func() {
+			bytes := cw.written
+			if r.ContentLength > 0 {
+				bytes += r.ContentLength
+			}
+			for _, a := range toAcquire {
+				l.release(a.name, a.limits, bytes)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,185 @@
+package s2
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limitsTestController is a `LimitsController` with fixed limits
+type limitsTestController struct {
+	accessKeyLimits *Limits
+	bucketLimits    *Limits
+}
+
+func (c limitsTestController) AccessKeyLimits(ctx context.Context, r *http.Request, accessKey string) (*Limits, error) {
+	return c.accessKeyLimits, nil
+}
+
+func (c limitsTestController) BucketLimits(ctx context.Context, r *http.Request, bucket string) (*Limits, error) {
+	return c.bucketLimits, nil
+}
+
+// limitsTestObjectController is an `ObjectController` that serves fixed
+// content, optionally blocking until it's unblocked
+type limitsTestObjectController struct {
+	ObjectController
+	content []byte
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (c limitsTestObjectController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	if c.unblock != nil {
+		c.started <- struct{}{}
+		<-c.unblock
+	}
+	return &GetObjectResult{Content: bytes.NewReader(c.content)}, nil
+}
+
+func serveLimitsTest(handler http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/bucket/key", nil))
+	return rec
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  *Limits
+		content string
+		codes   []int
+	}{
+		{
+			name:   "unlimited",
+			limits: nil,
+			codes:  []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:   "request rate",
+			limits: &Limits{RequestsPerSecond: 0.001, RequestBurst: 2},
+			codes:  []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable},
+		},
+		{
+			name:    "bandwidth",
+			limits:  &Limits{BytesPerSecond: 0.001, ByteBurst: 10},
+			content: "more than ten bytes",
+			codes:   []int{http.StatusOK, http.StatusServiceUnavailable},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newAuthTestS2(nil, nil)
+			s.Object = limitsTestObjectController{content: []byte(test.content)}
+			s.Limits = limitsTestController{bucketLimits: test.limits}
+			router := s.Router()
+
+			for i, code := range test.codes {
+				rec := serveLimitsTest(router)
+				if rec.Code != code {
+					t.Fatalf("unexpected status code %d for request %d: %s", rec.Code, i, rec.Body.String())
+				}
+				if code == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "SlowDown") {
+					t.Errorf("unexpected response: %s", rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLimitsInFlight(t *testing.T) {
+	controller := limitsTestObjectController{
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	s := newAuthTestS2(nil, nil)
+	s.Object = controller
+	s.Limits = limitsTestController{bucketLimits: &Limits{MaxInFlight: 1}}
+	router := s.Router()
+
+	done := make(chan int)
+	go func() {
+		done <- serveLimitsTest(router).Code
+	}()
+	<-controller.started
+
+	if rec := serveLimitsTest(router); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	close(controller.unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	go func() { <-controller.started }()
+	if rec := serveLimitsTest(router); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLimitsAccessKey(t *testing.T) {
+	s := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	s.Limits = limitsTestController{
+		accessKeyLimits: &Limits{RequestsPerSecond: 0.001, RequestBurst: 1},
+		bucketLimits:    &Limits{RequestsPerSecond: 0.001, RequestBurst: 2},
+	}
+	router := s.Router()
+
+	for i, code := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		r := httptest.NewRequest("GET", "/bucket/key", nil)
+		signV4(r, testAccessKey, testSecretKey, time.Now())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != code {
+			t.Fatalf("unexpected status code %d for request %d: %s", rec.Code, i, rec.Body.String())
+		}
+	}
+
+	// the bucket's request token is refunded when the access key's limits
+	// are exceeded, so unauthenticated requests can still use it
+	s.Auth = nil
+	if rec := serveLimitsTest(s.Router()); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLimiterEviction(t *testing.T) {
+	l := newLimiter()
+	fast := &Limits{RequestsPerSecond: 1, RequestBurst: 1}
+	slow := &Limits{RequestsPerSecond: 0.001, RequestBurst: 1}
+	now := time.Now()
+
+	// refilled without requests in flight
+	if !l.acquire("idle", fast, now) {
+		t.Fatalf("expected request to be admitted")
+	}
+	l.release("idle", fast, 0)
+	// not refilled yet
+	if !l.acquire("throttled", slow, now) {
+		t.Fatalf("expected request to be admitted")
+	}
+	l.release("throttled", slow, 0)
+	// with a request in flight
+	if !l.acquire("inFlight", fast, now) {
+		t.Fatalf("expected request to be admitted")
+	}
+
+	if !l.acquire("new", fast, now.Add(2*time.Minute)) {
+		t.Fatalf("expected request to be admitted")
+	}
+	for name, expected := range map[string]bool{"idle": false, "throttled": true, "inFlight": true, "new": true} {
+		if _, ok := l.states[name]; ok != expected {
+			t.Errorf("unexpected presence of state %q: %v", name, ok)
+		}
+	}
+
+	// the throttled state was kept, so it still has no tokens
+	if l.acquire("throttled", slow, now.Add(3*time.Minute)) {
+		t.Errorf("expected request to be throttled")
+	}
+}
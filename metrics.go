@@ -0,0 +1,123 @@
+package s2
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsOperation is the name of the route serving metrics, which is
+	// neither authenticated nor instrumented
+	metricsOperation = "Metrics"
+	// notImplementedOperation is the operation that requests to routes
+	// without an operation name are attributed to
+	notImplementedOperation = "NotImplemented"
+)
+
+// Metrics are Prometheus metrics of the requests served by s2, labeled by S3
+// operation (e.g. `GetObject` or `PutObject`.) The operation is taken from
+// the name of the route a request matched rather than its path, so that
+// label cardinality stays bounded. Requests that don't match any route
+// aren't counted.
+type Metrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+}
+
+// NewMetrics creates a new set of metrics, registered with their own
+// registry
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "s2",
+			Name:      "requests_total",
+			Help:      "The number of requests served, by S3 operation and HTTP status.",
+		}, []string{"operation", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "s2",
+			Name:      "request_duration_seconds",
+			Help:      "The time taken to serve requests, by S3 operation and HTTP status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "status"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "s2",
+			Name:      "request_bytes_total",
+			Help:      "The number of request body bytes received, by S3 operation.",
+		}, []string{"operation"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "s2",
+			Name:      "response_bytes_total",
+			Help:      "The number of response body bytes sent, by S3 operation.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "s2",
+			Name:      "errors_total",
+			Help:      "The number of error responses, by S3 operation and S3 error code.",
+		}, []string{"operation", "code"}),
+	}
+	m.registry.MustRegister(m.requests, m.duration, m.requestBytes, m.responseBytes, m.errors)
+	return m
+}
+
+// Registry returns the registry the metrics are registered with, e.g. for
+// registering additional collectors, or for serving the metrics elsewhere
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler creates a handler that serves the metrics in Prometheus' text
+// format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observe records a served request
+func (m *Metrics) observe(operation string, status int, errorCode string, requestBytes, responseBytes int64, duration time.Duration) {
+	statusStr := strconv.Itoa(status)
+	m.requests.WithLabelValues(operation, statusStr).Inc()
+	m.duration.WithLabelValues(operation, statusStr).Observe(duration.Seconds())
+	if requestBytes > 0 {
+		m.requestBytes.WithLabelValues(operation).Add(float64(requestBytes))
+	}
+	m.responseBytes.WithLabelValues(operation).Add(float64(responseBytes))
+	if errorCode != "" {
+		m.errors.WithLabelValues(operation, errorCode).Inc()
+	}
+}
+
+// operationName gets the name of the S3 operation a request is for, from
+// the name of the route it matched
+func operationName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return notImplementedOperation
+}
+
+// metricsMiddleware creates a middleware handler that records metrics of
+// every request
+func (h *S2) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := operationName(r)
+		if operation == metricsOperation {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		r, errorCode := withErrorCodeRecorder(r)
+		cw := &countingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		h.Metrics.observe(operation, cw.statusCode(), *errorCode, r.ContentLength, cw.written, time.Since(start))
+	})
+}
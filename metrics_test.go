@@ -0,0 +1,86 @@
+package s2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	s.Metrics = NewMetrics()
+	s.ServeMetrics = true
+	router := s.Router()
+
+	if code := serveSigned(router, testSecretKey); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	if code := serveSigned(router, "wrong"); code != http.StatusForbidden {
+		t.Fatalf("unexpected status code %d", code)
+	}
+
+	// metrics are served without auth
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	for _, expected := range []string{
+		`s2_requests_total{operation="GetObject",status="200"} 1`,
+		`s2_requests_total{operation="GetObject",status="403"} 1`,
+		`s2_errors_total{code="SignatureDoesNotMatch",operation="GetObject"} 1`,
+		`s2_request_duration_seconds_count{operation="GetObject",status="200"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("missing metric %q in:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, `operation="Metrics"`) {
+		t.Errorf("unexpected metrics of the metrics endpoint:\n%s", body)
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	router := newAuthTestS2(nil, nil).Router()
+
+	tests := []struct {
+		method    string
+		target    string
+		header    string
+		operation string
+	}{
+		{method: "GET", target: "/", operation: "ListBuckets"},
+		{method: "GET", target: "/bucket", operation: "ListObjects"},
+		{method: "GET", target: "/bucket/", operation: "ListObjects"},
+		{method: "GET", target: "/bucket?uploads", operation: "ListMultipartUploads"},
+		{method: "PUT", target: "/bucket/key", operation: "PutObject"},
+		{method: "PUT", target: "/bucket/key", header: "/src/key", operation: "CopyObject"},
+		{method: "PUT", target: "/bucket/key?uploadId=upload&partNumber=1", header: "/src/key", operation: "UploadPartCopy"},
+		{method: "GET", target: "/bucket/key", operation: "GetObject"},
+		{method: "GET", target: "/bucket?acl", operation: "NotImplemented"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if test.header != "" {
+			r.Header.Set("x-amz-copy-source", test.header)
+		}
+		var match mux.RouteMatch
+		if !router.Match(r, &match) {
+			t.Errorf("%s %s: no route matched", test.method, test.target)
+			continue
+		}
+		operation := match.Route.GetName()
+		if operation == "" {
+			operation = notImplementedOperation
+		}
+		if operation != test.operation {
+			t.Errorf("%s %s: unexpected operation %q", test.method, test.target, operation)
+		}
+	}
+}
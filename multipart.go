@@ -1,6 +1,9 @@
 package s2
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -22,6 +25,9 @@ const (
 	// maxPartsAllowed specifies the maximum number of parts that can be
 	// uploaded in a multipart upload
 	maxPartsAllowed = 10000
+	// defaultMinPartSize specifies the minimum size of every part but the
+	// last in a multipart upload, when part validation is enabled
+	defaultMinPartSize = 5 * 1024 * 1024
 	// completeMultipartPing is how long to wait before sending whitespace in
 	// a complete multipart response (to ensure the connection doesn't close.)
 	completeMultipartPing = 10 * time.Second
@@ -53,6 +59,112 @@ type Part struct {
 	// ETag is a hex encoding of the hash of the object contents, with or
 	// without surrounding quotes.
 	ETag string `xml:"ETag"`
+	// Size specifies the size of the part. This is not set for parts
+	// specified in CompleteMultipartUpload requests.
+	Size uint64 `xml:"Size,omitempty"`
+	// LastModified specifies when the part was uploaded. This is not set
+	// for parts specified in CompleteMultipartUpload requests.
+	LastModified time.Time `xml:"LastModified"`
+	// ChecksumCRC32 is the base64-encoded CRC32 checksum of the part, if
+	// one was specified when it was uploaded
+	ChecksumCRC32 string `xml:"ChecksumCRC32,omitempty"`
+	// ChecksumCRC32C is the base64-encoded CRC32C checksum of the part, if
+	// one was specified when it was uploaded
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	// ChecksumCRC64NVME is the base64-encoded CRC64NVME checksum of the
+	// part, if one was specified when it was uploaded
+	ChecksumCRC64NVME string `xml:"ChecksumCRC64NVME,omitempty"`
+	// ChecksumSHA1 is the base64-encoded SHA1 checksum of the part, if one
+	// was specified when it was uploaded
+	ChecksumSHA1 string `xml:"ChecksumSHA1,omitempty"`
+	// ChecksumSHA256 is the base64-encoded SHA256 checksum of the part, if
+	// one was specified when it was uploaded
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+// MarshalXML encodes a part, omitting its `LastModified` element if it's not
+// set, which `omitempty` doesn't support for times
+func (p Part) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	// `part` has the same fields as `Part`, but not this method
+	type part Part
+	encoded := struct {
+		part
+		LastModified *time.Time `xml:"LastModified,omitempty"`
+	}{
+		part: part(p),
+	}
+	if !isZeroTime(p.LastModified) {
+		encoded.LastModified = &p.LastModified
+	}
+	return e.EncodeElement(encoded, start)
+}
+
+// Checksum returns the part's base64-encoded checksum for the given
+// algorithm, or an empty string if it has none
+func (p *Part) Checksum(algorithm string) string {
+	switch algorithm {
+	case ChecksumCRC32:
+		return p.ChecksumCRC32
+	case ChecksumCRC32C:
+		return p.ChecksumCRC32C
+	case ChecksumCRC64NVME:
+		return p.ChecksumCRC64NVME
+	case ChecksumSHA1:
+		return p.ChecksumSHA1
+	case ChecksumSHA256:
+		return p.ChecksumSHA256
+	default:
+		return ""
+	}
+}
+
+// SetChecksum sets the part's checksum field for the checksum's algorithm.
+// A nil checksum is ignored.
+func (p *Part) SetChecksum(checksum *Checksum) {
+	if checksum == nil {
+		return
+	}
+	switch checksum.Algorithm {
+	case ChecksumCRC32:
+		p.ChecksumCRC32 = checksum.Value
+	case ChecksumCRC32C:
+		p.ChecksumCRC32C = checksum.Value
+	case ChecksumCRC64NVME:
+		p.ChecksumCRC64NVME = checksum.Value
+	case ChecksumSHA1:
+		p.ChecksumSHA1 = checksum.Value
+	case ChecksumSHA256:
+		p.ChecksumSHA256 = checksum.Value
+	}
+}
+
+// partChecksumAlgorithm returns the algorithm of the checksum set on a
+// part, or an empty string if it has none
+func partChecksumAlgorithm(part *Part) string {
+	for algorithm := range checksumAlgorithms {
+		if part.Checksum(algorithm) != "" {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// MultipartETag computes the S3-style ETag of an object created via a
+// multipart upload from its parts' ETags: the hex encoding of the MD5 hash
+// of the concatenated (binary) MD5 hashes of the parts, followed by a dash
+// and the number of parts. Part ETags may be with or without surrounding
+// quotes, but must be hex-encoded MD5 hashes. The returned ETag does not
+// have surrounding quotes.
+func MultipartETag(parts []*Part) (string, error) {
+	hash := md5.New()
+	for _, part := range parts {
+		partHash, err := hex.DecodeString(stripETagQuotes(part.ETag))
+		if err != nil || len(partHash) != md5.Size {
+			return "", fmt.Errorf("part %d has an invalid ETag: %q", part.PartNumber, part.ETag)
+		}
+		hash.Write(partHash)
+	}
+	return fmt.Sprintf("%x-%d", hash.Sum(nil), len(parts)), nil
 }
 
 // ListMultipartResult is a response from a ListMultipart call
@@ -61,6 +173,9 @@ type ListMultipartResult struct {
 	IsTruncated bool
 	// Uploads are the list of uploads returned
 	Uploads []*Upload
+	// CommonPrefixes are the list of common prefixes returned, when a
+	// delimiter is specified
+	CommonPrefixes []*CommonPrefixes
 }
 
 // CompleteMultipartResult is a response from a CompleteMultipart call
@@ -73,6 +188,8 @@ type CompleteMultipartResult struct {
 	// Version is the version of the object, or an empty string if versioning
 	// is not enabled or supported.
 	Version string
+	// Checksum is the additional checksum of the object, if any
+	Checksum *Checksum
 }
 
 // ListMultipartChunksResult is a response from a ListMultipartChunks call
@@ -81,12 +198,19 @@ type ListMultipartChunksResult struct {
 	Initiator *User
 	// Owner specifies the owner of the object
 	Owner *User
-	// StorageClass specifies the storage class used for the object
+	// StorageClass specifies the storage class used for the object, as
+	// specified when the upload was initialized
 	StorageClass string
 	// IsTruncated specifies whether this is the end of the list or not
 	IsTruncated bool
 	// Parts are the list of parts returned
 	Parts []*Part
+	// ChecksumAlgorithm is the additional checksum algorithm of the
+	// upload, as specified when the upload was initialized
+	ChecksumAlgorithm string
+	// ChecksumType is the additional checksum type of the upload, as
+	// specified when the upload was initialized
+	ChecksumType string
 }
 
 // MultipartController is an interface that specifies multipart-related
@@ -107,6 +231,69 @@ type MultipartController interface {
 	UploadMultipartChunk(r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader) (string, error)
 }
 
+// ListMultipartPrefixController is an optional interface that a
+// `MultipartController` can implement to filter and group listings of
+// in-progress uploads. If implemented, its method is called instead of
+// `ListMultipart`. If it's not implemented, listings with a prefix or
+// delimiter are rejected with `NotImplementedError`.
+type ListMultipartPrefixController interface {
+	// ListMultipartWithPrefix lists in-progress multipart uploads in a
+	// bucket. When a delimiter is specified, `keyMarker` may be a common
+	// prefix from a previous page (with an empty `uploadIDMarker`), in
+	// which case every upload under that prefix should be skipped.
+	ListMultipartWithPrefix(r *http.Request, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*ListMultipartResult, error)
+}
+
+// MultipartAttributesController is an optional interface that a
+// `MultipartController` can implement to persist the attributes and
+// additional checksums of uploaded objects, and to evaluate write
+// preconditions on completion. If implemented, its methods are called
+// instead of `InitMultipart`, `CompleteMultipart` and
+// `UploadMultipartChunk`. If it's not implemented, attributes and checksums
+// are dropped, and completions with preconditions are rejected with
+// `NotImplementedError`.
+type MultipartAttributesController interface {
+	// InitMultipartWithAttributes initializes a new multipart upload.
+	// `attrs` are the attributes the object should have once the upload is
+	// completed, and should be persisted until then.
+	InitMultipartWithAttributes(r *http.Request, bucket, key string, attrs *ObjectAttributes) (string, error)
+	// CompleteMultipartWithAttributes finishes a multipart upload.
+	// `checksum` is the additional checksum of the object computed from the
+	// parts' checksums, or nil if the parts don't have any. If
+	// `precondition` is non-nil, the object should only be written if it
+	// holds.
+	CompleteMultipartWithAttributes(r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error)
+	// UploadMultipartChunkWithChecksum uploads a chunk of an in-progress
+	// multipart upload. `checksum` is the validated additional checksum of
+	// the chunk, or nil if none was specified; it should be returned on the
+	// part when listing chunks. As with `PutObjectWithAttributes`,
+	// checksums of streaming uploads are validated as `reader` is read, and
+	// trailing checksums only have their value set once it has returned
+	// `io.EOF`.
+	UploadMultipartChunkWithChecksum(r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader, checksum *Checksum) (string, error)
+}
+
+// MultipartContextController is a context-aware variant of
+// `MultipartController`, which also includes the methods of
+// `ListMultipartPrefixController` and `MultipartAttributesController`. See
+// those interfaces for details of each method's arguments.
+type MultipartContextController interface {
+	// ListMultipart lists in-progress multipart uploads in a bucket
+	ListMultipart(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*ListMultipartResult, error)
+	// InitMultipart initializes a new multipart upload
+	InitMultipart(ctx context.Context, r *http.Request, bucket, key string, attrs *ObjectAttributes) (string, error)
+	// AbortMultipart aborts an in-progress multipart upload
+	AbortMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string) error
+	// CompleteMultipart finishes a multipart upload. `ctx` is cancelled if
+	// the client disconnects before the upload is completed.
+	CompleteMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error)
+	// ListMultipartChunks lists the constituent chunks of an in-progress
+	// multipart upload
+	ListMultipartChunks(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error)
+	// UploadMultipartChunk uploads a chunk of an in-progress multipart upload
+	UploadMultipartChunk(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader, checksum *Checksum) (string, error)
+}
+
 // unimplementedMultipartController defines a controller that returns
 // `NotImplementedError` for all functionality
 type unimplementedMultipartController struct{}
@@ -136,19 +323,26 @@ func (c unimplementedMultipartController) UploadMultipartChunk(r *http.Request,
 }
 
 type multipartHandler struct {
-	controller MultipartController
-	logger     *logrus.Entry
+	controller           MultipartContextController
+	objectController     ObjectContextController
+	headObjectController HeadObjectContextController
+	quota                QuotaController
+	logger               *logrus.Entry
+	validateParts        bool
+	minPartSize          uint64
 }
 
 func (h *multipartHandler) list(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	prefix := r.FormValue("prefix")
 	keyMarker := r.FormValue("key-marker")
 	uploadIDMarker := r.FormValue("upload-id-marker")
 	if keyMarker == "" {
 		uploadIDMarker = ""
 	}
+	delimiter := r.FormValue("delimiter")
 
 	maxUploads, err := intFormValue(r, "max-uploads", 0, defaultMaxUploads, defaultMaxUploads)
 	if err != nil {
@@ -156,7 +350,7 @@ func (h *multipartHandler) list(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.controller.ListMultipart(r, bucket, keyMarker, uploadIDMarker, maxUploads)
+	result, err := h.controller.ListMultipart(r.Context(), r, bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -169,22 +363,28 @@ func (h *multipartHandler) list(w http.ResponseWriter, r *http.Request) {
 	}
 
 	marshallable := struct {
-		XMLName            xml.Name  `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
-		Bucket             string    `xml:"Bucket"`
-		KeyMarker          string    `xml:"KeyMarker"`
-		UploadIDMarker     string    `xml:"UploadIdMarker"`
-		NextKeyMarker      string    `xml:"NextKeyMarker"`
-		NextUploadIDMarker string    `xml:"NextUploadIdMarker"`
-		MaxUploads         int       `xml:"MaxUploads"`
-		IsTruncated        bool      `xml:"IsTruncated"`
-		Uploads            []*Upload `xml:"Upload"`
+		XMLName            xml.Name          `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
+		Bucket             string            `xml:"Bucket"`
+		KeyMarker          string            `xml:"KeyMarker"`
+		UploadIDMarker     string            `xml:"UploadIdMarker"`
+		NextKeyMarker      string            `xml:"NextKeyMarker"`
+		NextUploadIDMarker string            `xml:"NextUploadIdMarker"`
+		Prefix             string            `xml:"Prefix"`
+		Delimiter          string            `xml:"Delimiter,omitempty"`
+		MaxUploads         int               `xml:"MaxUploads"`
+		IsTruncated        bool              `xml:"IsTruncated"`
+		Uploads            []*Upload         `xml:"Upload"`
+		CommonPrefixes     []*CommonPrefixes `xml:"CommonPrefixes"`
 	}{
 		Bucket:         bucket,
 		KeyMarker:      keyMarker,
 		UploadIDMarker: uploadIDMarker,
+		Prefix:         prefix,
+		Delimiter:      delimiter,
 		MaxUploads:     maxUploads,
 		IsTruncated:    result.IsTruncated,
 		Uploads:        result.Uploads,
+		CommonPrefixes: result.CommonPrefixes,
 	}
 
 	if marshallable.IsTruncated {
@@ -192,13 +392,17 @@ func (h *multipartHandler) list(w http.ResponseWriter, r *http.Request) {
 		highUploadID := ""
 
 		for _, upload := range marshallable.Uploads {
-			if upload.Key > highKey {
+			if upload.Key > highKey || (upload.Key == highKey && upload.UploadID > highUploadID) {
 				highKey = upload.Key
-			}
-			if upload.UploadID > highUploadID {
 				highUploadID = upload.UploadID
 			}
 		}
+		for _, commonPrefix := range marshallable.CommonPrefixes {
+			if commonPrefix.Prefix > highKey {
+				highKey = commonPrefix.Prefix
+				highUploadID = ""
+			}
+		}
 
 		marshallable.NextKeyMarker = highKey
 		marshallable.NextUploadIDMarker = highUploadID
@@ -226,12 +430,19 @@ func (h *multipartHandler) listChunks(w http.ResponseWriter, r *http.Request) {
 
 	uploadID := r.FormValue("uploadId")
 
-	result, err := h.controller.ListMultipartChunks(r, bucket, key, uploadID, partNumberMarker, maxParts)
+	result, err := h.controller.ListMultipartChunks(r.Context(), r, bucket, key, uploadID, partNumberMarker, maxParts)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
 
+	// some clients (e.g. minio-python) can't handle sub-seconds in datetime
+	// output
+	for _, part := range result.Parts {
+		part.LastModified = part.LastModified.UTC().Round(time.Second)
+		part.ETag = addETagQuotes(part.ETag)
+	}
+
 	marshallable := struct {
 		XMLName              xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
 		Bucket               string   `xml:"Bucket"`
@@ -244,18 +455,22 @@ func (h *multipartHandler) listChunks(w http.ResponseWriter, r *http.Request) {
 		NextPartNumberMarker int      `xml:"NextPartNumberMarker"`
 		MaxParts             int      `xml:"MaxParts"`
 		IsTruncated          bool     `xml:"IsTruncated"`
+		ChecksumAlgorithm    string   `xml:"ChecksumAlgorithm,omitempty"`
+		ChecksumType         string   `xml:"ChecksumType,omitempty"`
 		Parts                []*Part  `xml:"Part"`
 	}{
-		Bucket:           bucket,
-		Key:              key,
-		UploadID:         uploadID,
-		PartNumberMarker: partNumberMarker,
-		MaxParts:         maxParts,
-		Initiator:        result.Initiator,
-		Owner:            result.Owner,
-		StorageClass:     result.StorageClass,
-		IsTruncated:      result.IsTruncated,
-		Parts:            result.Parts,
+		Bucket:            bucket,
+		Key:               key,
+		UploadID:          uploadID,
+		PartNumberMarker:  partNumberMarker,
+		MaxParts:          maxParts,
+		Initiator:         result.Initiator,
+		Owner:             result.Owner,
+		StorageClass:      result.StorageClass,
+		IsTruncated:       result.IsTruncated,
+		ChecksumAlgorithm: result.ChecksumAlgorithm,
+		ChecksumType:      result.ChecksumType,
+		Parts:             result.Parts,
 	}
 
 	if marshallable.IsTruncated {
@@ -278,12 +493,29 @@ func (h *multipartHandler) init(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	key := vars["key"]
 
-	uploadID, err := h.controller.InitMultipart(r, bucket, key)
+	attrs, err := objectAttributesFromRequest(r)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
 
+	attrs.Checksum, err = multipartChecksumFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	uploadID, err := h.controller.InitMultipart(r.Context(), r, bucket, key, attrs)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	if attrs.Checksum != nil {
+		w.Header().Set("x-amz-checksum-algorithm", attrs.Checksum.Algorithm)
+		w.Header().Set("x-amz-checksum-type", attrs.Checksum.Type)
+	}
+
 	marshallable := struct {
 		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
 		Bucket   string   `xml:"Bucket"`
@@ -310,6 +542,12 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 
 	uploadID := r.FormValue("uploadId")
 
+	precondition, err := writePreconditionFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
 	payload := struct {
 		XMLName xml.Name `xml:"CompleteMultipartUpload"`
 		Parts   []*Part  `xml:"Part"`
@@ -332,13 +570,69 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 		part.ETag = addETagQuotes(part.ETag)
 	}
 
+	expectedChecksum, err := expectedMultipartChecksumFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	// all parts that specify a checksum must use the same algorithm
+	partsAlgorithm := ""
+	for _, part := range payload.Parts {
+		if algorithm := partChecksumAlgorithm(part); algorithm != "" {
+			if partsAlgorithm != "" && algorithm != partsAlgorithm {
+				WriteError(h.logger, w, r, InvalidRequestError(r, "All parts must use the same checksum algorithm."))
+				return
+			}
+			partsAlgorithm = algorithm
+		}
+	}
+
+	// the uploaded parts are also listed to get the size of the object for
+	// quota checks
+	var checksum *Checksum
+	size := int64(-1)
+	needsChecksum := h.validateParts || partsAlgorithm != "" || expectedChecksum != nil
+	if needsChecksum || h.quota != nil {
+		uploadedParts, listing, err := h.uploadedParts(r, bucket, key, uploadID)
+		if err != nil {
+			WriteError(h.logger, w, r, err)
+			return
+		}
+
+		if h.validateParts {
+			if err := h.checkParts(r, payload.Parts, uploadedParts); err != nil {
+				WriteError(h.logger, w, r, err)
+				return
+			}
+		}
+
+		if needsChecksum {
+			checksum, err = multipartChecksum(r, payload.Parts, uploadedParts, listing, partsAlgorithm, expectedChecksum)
+			if err != nil {
+				WriteError(h.logger, w, r, err)
+				return
+			}
+		}
+
+		size = completedSize(payload.Parts, uploadedParts)
+	}
+
+	if err := checkQuota(h.quota, r, QuotaOpCompleteMultipartUpload, bucket, key, uploadID, size); err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	// buffered so that the goroutine can exit even if the response is no
+	// longer being waited on
+	ctx := r.Context()
 	ch := make(chan struct {
 		result *CompleteMultipartResult
 		err    error
-	})
+	}, 1)
 
 	go func() {
-		result, err := h.controller.CompleteMultipart(r, bucket, key, uploadID, payload.Parts)
+		result, err := h.controller.CompleteMultipart(ctx, r, bucket, key, uploadID, payload.Parts, checksum, precondition)
 		ch <- struct {
 			result *CompleteMultipartResult
 			err    error
@@ -357,17 +651,21 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 				s3Error := newGenericError(r, value.err)
 
 				if streaming {
+					recordErrorCode(r, s3Error.Code)
 					writeXMLBody(h.logger, w, s3Error)
 				} else {
 					WriteError(h.logger, w, r, s3Error)
 				}
 			} else {
+				reconcileQuota(h.logger, h.quota, r, QuotaOpCompleteMultipartUpload, bucket, key, uploadID, value.result.Version, size)
+
 				marshallable := struct {
 					XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
 					Location string   `xml:"Location"`
 					Bucket   string   `xml:"Bucket"`
 					Key      string   `xml:"Key"`
 					ETag     string   `xml:"ETag"`
+					checksumElements
 				}{
 					Bucket:   bucket,
 					Key:      key,
@@ -375,6 +673,12 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 					ETag:     addETagQuotes(value.result.ETag),
 				}
 
+				resultChecksum := value.result.Checksum
+				if resultChecksum == nil {
+					resultChecksum = checksum
+				}
+				marshallable.checksumElements = newChecksumElements(resultChecksum)
+
 				if value.result.Version != "" {
 					w.Header().Set("x-amz-version-id", value.result.Version)
 				}
@@ -386,6 +690,12 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			return
+		case <-ctx.Done():
+			// the client has disconnected, so there's no one to respond to.
+			// The controller is expected to stop once it sees the
+			// cancellation.
+			h.logger.Infof("client disconnected while completing multipart upload %s: %v", uploadID, ctx.Err())
+			return
 		case <-time.After(completeMultipartPing):
 			if !streaming {
 				streaming = true
@@ -397,6 +707,63 @@ func (h *multipartHandler) complete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// uploadedParts lists all of the parts that have been uploaded in a
+// multipart upload via `ListMultipartChunks`, keyed by part number. It also
+// returns the first page of the listing, for upload-level details.
+func (h *multipartHandler) uploadedParts(r *http.Request, bucket, key, uploadID string) (map[int]*Part, *ListMultipartChunksResult, error) {
+	var listing *ListMultipartChunksResult
+	uploadedParts := map[int]*Part{}
+	partNumberMarker := 0
+	for {
+		result, err := h.controller.ListMultipartChunks(r.Context(), r, bucket, key, uploadID, partNumberMarker, defaultMaxParts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if listing == nil {
+			listing = result
+		}
+		previousMarker := partNumberMarker
+		for _, part := range result.Parts {
+			uploadedParts[part.PartNumber] = part
+			if part.PartNumber > partNumberMarker {
+				partNumberMarker = part.PartNumber
+			}
+		}
+		if !result.IsTruncated || len(result.Parts) == 0 {
+			break
+		}
+		// guard against controllers that ignore the marker, which would
+		// otherwise return the same page forever
+		if partNumberMarker == previousMarker {
+			return nil, nil, InternalError(r, fmt.Errorf("listing the parts of upload %s did not advance past part %d", uploadID, partNumberMarker))
+		}
+	}
+	return uploadedParts, listing, nil
+}
+
+// checkParts validates the parts list of a CompleteMultipartUpload request
+// against the parts that have been uploaded. It expects `parts` to be
+// sorted by part number.
+func (h *multipartHandler) checkParts(r *http.Request, parts []*Part, uploadedParts map[int]*Part) error {
+	for i := 1; i < len(parts); i++ {
+		if parts[i].PartNumber == parts[i-1].PartNumber {
+			return InvalidPartOrderError(nil, r)
+		}
+	}
+
+	for i, part := range parts {
+		uploadedPart, ok := uploadedParts[part.PartNumber]
+		if !ok || addETagQuotes(uploadedPart.ETag) != part.ETag {
+			return InvalidPartError(r)
+		}
+		if i < len(parts)-1 && uploadedPart.Size < h.minPartSize {
+			return EntityTooSmallError(r)
+		}
+	}
+
+	return nil
+}
+
 func (h *multipartHandler) put(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
@@ -409,19 +776,122 @@ func (h *multipartHandler) put(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	etag, err := h.controller.UploadMultipartChunk(r, bucket, key, uploadID, partNumber, r.Body)
+	checksum, err := checksumFromRequest(r)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
 
+	body, checksum, err := chunkedBody(r, checksum)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	// the quota was checked by `quotaMiddleware`, but if the size wasn't
+	// declared, it's reconciled with the number of bytes read
+	size := declaredSize(r)
+	var counter *countingReader
+	if size < 0 && h.quota != nil {
+		counter = &countingReader{ReadCloser: body}
+		body = counter
+	}
+
+	etag, err := h.controller.UploadMultipartChunk(r.Context(), r, bucket, key, uploadID, partNumber, body, checksum)
+	if err != nil {
+		WriteError(h.logger, w, r, chunkedReaderError(r, err))
+		return
+	}
+	if counter != nil {
+		size = counter.n
+	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpUploadPart, bucket, key, uploadID, "", size)
+
 	if etag != "" {
 		w.Header().Set("ETag", addETagQuotes(etag))
 	}
+	if checksum != nil && checksum.Value != "" {
+		w.Header().Set(checksumHeader(checksum.Algorithm), checksum.Value)
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *multipartHandler) copy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	uploadID := r.FormValue("uploadId")
+	partNumber, err := intFormValue(r, "partNumber", 1, maxPartsAllowed, 0)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	srcBucket, srcKey, srcVersionID, err := copySourceHeader(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	getResult, err := getCopySource(r, h.objectController, h.headObjectController, srcBucket, srcKey, srcVersionID)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	size, err := getResult.Content.Seek(0, io.SeekEnd)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	offset, length, err := copySourceRangeHeader(r, uint64(size))
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	if _, err := getResult.Content.Seek(int64(offset), io.SeekStart); err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	if err := checkQuota(h.quota, r, QuotaOpUploadPartCopy, bucket, key, uploadID, int64(length)); err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	reader := io.LimitReader(getResult.Content, int64(length))
+	etag, err := h.controller.UploadMultipartChunk(r.Context(), r, bucket, key, uploadID, partNumber, reader, nil)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpUploadPartCopy, bucket, key, uploadID, "", int64(length))
+
+	if getResult.Version != "" {
+		w.Header().Set("x-amz-copy-source-version-id", getResult.Version)
+	}
+
+	modTime := getResult.ModTime
+	if isZeroTime(modTime) {
+		modTime = time.Now()
+	}
+
+	marshallable := struct {
+		XMLName      xml.Name  `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyPartResult"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	}{
+		// some clients (e.g. minio-python) can't handle sub-seconds in
+		// datetime output
+		LastModified: modTime.UTC().Round(time.Second),
+		ETag:         addETagQuotes(etag),
+	}
+
+	writeXML(h.logger, w, r, http.StatusOK, marshallable)
+}
+
 func (h *multipartHandler) del(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
@@ -429,10 +899,11 @@ func (h *multipartHandler) del(w http.ResponseWriter, r *http.Request) {
 
 	uploadID := r.FormValue("uploadId")
 
-	if err := h.controller.AbortMultipart(r, bucket, key, uploadID); err != nil {
+	if err := h.controller.AbortMultipart(r.Context(), r, bucket, key, uploadID); err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpAbortMultipartUpload, bucket, key, uploadID, "", -1)
 
 	w.WriteHeader(http.StatusNoContent)
 }
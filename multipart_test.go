@@ -0,0 +1,471 @@
+package s2
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMultipartETag(t *testing.T) {
+	etag, err := MultipartETag([]*Part{
+		// md5("hello")
+		{PartNumber: 1, ETag: "5d41402abc4b2a76b9719d911017c592"},
+		// md5("world")
+		{PartNumber: 2, ETag: `"7d793037a0760186574b0282f2f435e7"`},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if etag != "065947336a2f2a95ba8899f3675c3be6-2" {
+		t.Errorf("unexpected etag: %q", etag)
+	}
+
+	if _, err := MultipartETag([]*Part{{PartNumber: 1, ETag: "not-an-md5"}}); err == nil {
+		t.Errorf("expected an error for an invalid part etag")
+	}
+}
+
+func TestCheckParts(t *testing.T) {
+	h := &multipartHandler{minPartSize: 5}
+	r := httptest.NewRequest("POST", "/bucket/key?uploadId=upload", nil)
+	uploadedParts := map[int]*Part{
+		1: {PartNumber: 1, ETag: "etag1", Size: 5},
+		2: {PartNumber: 2, ETag: "etag2", Size: 1},
+		3: {PartNumber: 3, ETag: "etag3", Size: 1},
+	}
+
+	tests := []struct {
+		name  string
+		parts []*Part
+		code  string
+	}{
+		{
+			name:  "complete",
+			parts: []*Part{{PartNumber: 1, ETag: `"etag1"`}, {PartNumber: 3, ETag: `"etag3"`}},
+		},
+		{
+			name:  "small last part",
+			parts: []*Part{{PartNumber: 1, ETag: `"etag1"`}, {PartNumber: 2, ETag: `"etag2"`}},
+		},
+		{
+			name:  "duplicate",
+			parts: []*Part{{PartNumber: 1, ETag: `"etag1"`}, {PartNumber: 1, ETag: `"etag1"`}},
+			code:  "InvalidPartOrder",
+		},
+		{
+			name:  "missing",
+			parts: []*Part{{PartNumber: 1, ETag: `"etag1"`}, {PartNumber: 4, ETag: `"etag4"`}},
+			code:  "InvalidPart",
+		},
+		{
+			name:  "etag mismatch",
+			parts: []*Part{{PartNumber: 1, ETag: `"etag2"`}},
+			code:  "InvalidPart",
+		},
+		{
+			name:  "too small",
+			parts: []*Part{{PartNumber: 2, ETag: `"etag2"`}, {PartNumber: 3, ETag: `"etag3"`}},
+			code:  "EntityTooSmall",
+		},
+	}
+
+	for _, test := range tests {
+		err := h.checkParts(r, test.parts, uploadedParts)
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if s3Err, ok := err.(*Error); !ok || s3Err.Code != test.code {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+// listMultipartTestController is a `MultipartController` that lists a fixed
+// set of uploads, sorted by key and upload ID
+type listMultipartTestController struct {
+	unimplementedMultipartController
+	uploads []*Upload
+}
+
+func (c listMultipartTestController) ListMultipartWithPrefix(r *http.Request, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*ListMultipartResult, error) {
+	result := &ListMultipartResult{}
+	lastPrefix := ""
+	for _, upload := range c.uploads {
+		if !strings.HasPrefix(upload.Key, prefix) {
+			continue
+		}
+		if upload.Key < keyMarker || (upload.Key == keyMarker && upload.UploadID <= uploadIDMarker) {
+			continue
+		}
+
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(upload.Key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = upload.Key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && (commonPrefix == lastPrefix || (uploadIDMarker == "" && commonPrefix <= keyMarker)) {
+			continue
+		}
+
+		if len(result.Uploads)+len(result.CommonPrefixes) >= maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		if commonPrefix != "" {
+			lastPrefix = commonPrefix
+			result.CommonPrefixes = append(result.CommonPrefixes, &CommonPrefixes{Prefix: commonPrefix})
+		} else {
+			result.Uploads = append(result.Uploads, upload)
+		}
+	}
+	return result, nil
+}
+
+func TestListMultipartPagination(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Multipart = listMultipartTestController{uploads: []*Upload{
+		{Key: "a/1", UploadID: "1"},
+		{Key: "a/2", UploadID: "1"},
+		{Key: "b", UploadID: "1"},
+		{Key: "b", UploadID: "2"},
+		{Key: "ba", UploadID: "1"},
+		{Key: "ba", UploadID: "2"},
+		{Key: "c/1", UploadID: "1"},
+		{Key: "d", UploadID: "1"},
+	}}
+	router := s.Router()
+
+	for maxUploads := 1; maxUploads <= 3; maxUploads++ {
+		var listed []string
+		keyMarker, uploadIDMarker := "", ""
+		for page := 0; ; page++ {
+			if page == 10 {
+				t.Fatalf("listing didn't terminate: %v", listed)
+			}
+
+			query := url.Values{"delimiter": {"/"}, "max-uploads": {strconv.Itoa(maxUploads)}, "key-marker": {keyMarker}, "upload-id-marker": {uploadIDMarker}}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/bucket?uploads&"+query.Encode(), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var result struct {
+				NextKeyMarker      string
+				NextUploadIDMarker string `xml:"NextUploadIdMarker"`
+				IsTruncated        bool
+				Uploads            []Upload         `xml:"Upload"`
+				CommonPrefixes     []CommonPrefixes `xml:"CommonPrefixes"`
+			}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("could not parse response: %v", err)
+			}
+			for _, upload := range result.Uploads {
+				listed = append(listed, upload.Key+"@"+upload.UploadID)
+			}
+			for _, commonPrefix := range result.CommonPrefixes {
+				listed = append(listed, commonPrefix.Prefix)
+			}
+			if !result.IsTruncated {
+				break
+			}
+			keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+		}
+
+		sort.Strings(listed)
+		expected := "a/,b@1,b@2,ba@1,ba@2,c/,d@1"
+		if strings.Join(listed, ",") != expected {
+			t.Errorf("unexpected listing with %d uploads per page: %v", maxUploads, listed)
+		}
+	}
+}
+
+// copyPartTestController is a `MultipartController` that records the
+// contents of the last part uploaded
+type copyPartTestController struct {
+	unimplementedMultipartController
+	content *string
+}
+
+func (c copyPartTestController) UploadMultipartChunk(r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader) (string, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	*c.content = string(content)
+	return "partetag", nil
+}
+
+func TestUploadPartCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		code    int
+		content string
+	}{
+		{name: "whole object", code: http.StatusOK, content: "hello world"},
+		{name: "first byte", headers: map[string]string{"x-amz-copy-source-range": "bytes=0-0"}, code: http.StatusOK, content: "h"},
+		{name: "prefix", headers: map[string]string{"x-amz-copy-source-range": "bytes=0-4"}, code: http.StatusOK, content: "hello"},
+		{name: "suffix", headers: map[string]string{"x-amz-copy-source-range": "bytes=6-10"}, code: http.StatusOK, content: "world"},
+		{name: "past the end", headers: map[string]string{"x-amz-copy-source-range": "bytes=6-11"}, code: http.StatusRequestedRangeNotSatisfiable},
+		{name: "maximum last byte", headers: map[string]string{"x-amz-copy-source-range": "bytes=0-18446744073709551615"}, code: http.StatusRequestedRangeNotSatisfiable},
+		{name: "overflowing last byte", headers: map[string]string{"x-amz-copy-source-range": "bytes=0-18446744073709551616"}, code: http.StatusBadRequest},
+		{name: "overflowing first byte", headers: map[string]string{"x-amz-copy-source-range": "bytes=18446744073709551616-18446744073709551617"}, code: http.StatusBadRequest},
+		{name: "reversed", headers: map[string]string{"x-amz-copy-source-range": "bytes=5-4"}, code: http.StatusBadRequest},
+		{name: "suffix length", headers: map[string]string{"x-amz-copy-source-range": "bytes=-5"}, code: http.StatusBadRequest},
+		{name: "open ended", headers: map[string]string{"x-amz-copy-source-range": "bytes=5-"}, code: http.StatusBadRequest},
+		{name: "missing unit", headers: map[string]string{"x-amz-copy-source-range": "0-4"}, code: http.StatusBadRequest},
+		{name: "multiple ranges", headers: map[string]string{"x-amz-copy-source-range": "bytes=0-1,3-4"}, code: http.StatusBadRequest},
+		{name: "part number zero", target: "/bucket/dest?uploadId=upload&partNumber=0", code: http.StatusBadRequest},
+		{name: "part number too large", target: "/bucket/dest?uploadId=upload&partNumber=10001", code: http.StatusBadRequest},
+		{name: "failing precondition", headers: map[string]string{"x-amz-copy-source-if-match": `"other"`}, code: http.StatusPreconditionFailed},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := ""
+			s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+			s.Object = partsTestHeadController{}
+			s.Multipart = copyPartTestController{content: &content}
+
+			target := test.target
+			if target == "" {
+				target = "/bucket/dest?uploadId=upload&partNumber=1"
+			}
+			r := httptest.NewRequest("PUT", target, nil)
+			r.Header.Set("x-amz-copy-source", "/bucket/single.txt")
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+
+			if content != test.content {
+				t.Errorf("unexpected part contents: %q", content)
+			}
+			var result struct {
+				LastModified time.Time
+				ETag         string
+			}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("could not parse response: %v", err)
+			}
+			if !result.LastModified.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected last modified time: %v", result.LastModified)
+			}
+			if result.ETag != `"partetag"` {
+				t.Errorf("unexpected etag: %q", result.ETag)
+			}
+		})
+	}
+}
+
+// stuckListingTestController is a `MultipartController` whose part listings
+// ignore the part number marker, always returning the same truncated page
+type stuckListingTestController struct {
+	unimplementedMultipartController
+	listings *int
+}
+
+func (c stuckListingTestController) ListMultipartChunks(r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error) {
+	*c.listings++
+	return &ListMultipartChunksResult{
+		IsTruncated: true,
+		Parts: []*Part{
+			{PartNumber: 1, ETag: `"1"`, Size: 5 * 1024 * 1024},
+			{PartNumber: 2, ETag: `"2"`, Size: 5 * 1024 * 1024},
+		},
+	}, nil
+}
+
+func TestUploadedPartsStuckListing(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	listings := 0
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Multipart = stuckListingTestController{listings: &listings}
+	s.ValidateMultipartParts = true
+
+	body := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"1"</ETag></Part><Part><PartNumber>2</PartNumber><ETag>"2"</ETag></Part></CompleteMultipartUpload>`
+	r := httptest.NewRequest("POST", "/bucket/key?uploadId=upload", strings.NewReader(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code %d, got %d: %s", http.StatusInternalServerError, rec.Code, rec.Body.String())
+	}
+	if listings != 2 {
+		t.Errorf("expected 2 listings, got %d", listings)
+	}
+}
+
+// listPartsTestController is a `MultipartController` that lists a fixed set
+// of parts
+type listPartsTestController struct {
+	unimplementedMultipartController
+	parts []*Part
+}
+
+func (c listPartsTestController) ListMultipartChunks(r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error) {
+	return &ListMultipartChunksResult{Parts: c.parts}, nil
+}
+
+func TestListPartsOptionalFields(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Multipart = listPartsTestController{
+		parts: []*Part{
+			{PartNumber: 1, ETag: "1"},
+			{PartNumber: 2, ETag: "2", Size: 5, LastModified: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, httptest.NewRequest("GET", "/bucket/key?uploadId=upload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	expected := []string{
+		`<Part><PartNumber>1</PartNumber><ETag>&#34;1&#34;</ETag></Part>`,
+		`<Size>5</Size>`,
+		`<LastModified>2019-01-01T00:00:00Z</LastModified>`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %q in response: %s", s, body)
+		}
+	}
+	if n := strings.Count(body, "<Size>"); n != 1 {
+		t.Errorf("expected 1 size, got %d: %s", n, body)
+	}
+	if n := strings.Count(body, "<LastModified>"); n != 1 {
+		t.Errorf("expected 1 last modified time, got %d: %s", n, body)
+	}
+}
+
+func TestUploadPartChunked(t *testing.T) {
+	crc := computeChecksum(ChecksumCRC32, []byte("hello world"))
+	badCRC := computeChecksum(ChecksumCRC32, []byte("goodbye"))
+
+	tests := []struct {
+		name    string
+		payload string
+		trailer string
+		body    string
+		code    int
+		errCode string
+	}{
+		{
+			name:    "signed",
+			payload: streamingPayload,
+			body:    signedChunkedBody([]string{"hello ", "world"}, ""),
+			code:    http.StatusOK,
+		},
+		{
+			name:    "signed with trailer",
+			payload: streamingPayloadTrailer,
+			trailer: "x-amz-checksum-crc32",
+			body:    signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+crc+"\n"),
+			code:    http.StatusOK,
+		},
+		{
+			name:    "tampered chunk",
+			payload: streamingPayload,
+			body:    strings.Replace(signedChunkedBody([]string{"hello ", "world"}, ""), "world", "wOrld", 1),
+			code:    http.StatusForbidden,
+			errCode: "SignatureDoesNotMatch",
+		},
+		{
+			name:    "bad trailing checksum",
+			payload: streamingPayloadTrailer,
+			trailer: "x-amz-checksum-crc32",
+			body:    signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+badCRC+"\n"),
+			code:    http.StatusBadRequest,
+			errCode: "BadDigest",
+		},
+		{
+			name:    "truncated",
+			payload: streamingPayload,
+			body:    truncateBefore(signedChunkedBody([]string{"hello ", "world"}, ""), "0;chunk-signature"),
+			code:    http.StatusForbidden,
+			errCode: "SignatureDoesNotMatch",
+		},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := ""
+			h := &multipartHandler{
+				controller: multipartControllerAdapter{controller: copyPartTestController{content: &content}},
+				logger:     logrus.NewEntry(logger),
+			}
+
+			r := httptest.NewRequest("PUT", "/bucket/key?uploadId=upload&partNumber=1", strings.NewReader(test.body))
+			r = mux.SetURLVars(r, map[string]string{"bucket": "bucket", "key": "key"})
+			r.Header.Set("x-amz-content-sha256", test.payload)
+			if test.trailer != "" {
+				r.Header.Set("x-amz-trailer", test.trailer)
+			}
+			r = withContextValue(r, chunkSignerContextKey, &chunkSigner{
+				signingKey:    testSigningKey,
+				seedSignature: testSeedSignature,
+				timestamp:     testChunkTimestamp,
+				date:          testChunkDate,
+				region:        testChunkRegion,
+			})
+			rec := httptest.NewRecorder()
+			h.put(rec, r)
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+			}
+			if test.code != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), "<Code>"+test.errCode+"</Code>") {
+					t.Errorf("unexpected response: %s", rec.Body.String())
+				}
+				return
+			}
+
+			if content != "hello world" {
+				t.Errorf("unexpected part contents: %q", content)
+			}
+			if v := rec.Header().Get("ETag"); v != `"partetag"` {
+				t.Errorf("unexpected etag: %q", v)
+			}
+			if v := rec.Header().Get("x-amz-checksum-crc32"); test.trailer != "" && v != crc {
+				t.Errorf("unexpected checksum: %q", v)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package s2
 
 import (
+	"context"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,6 +16,56 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// DirectiveCopy specifies that a copied object's metadata or tags should
+	// be copied from the source object
+	DirectiveCopy string = "COPY"
+	// DirectiveReplace specifies that a copied object's metadata or tags
+	// should be replaced with the ones specified in the request
+	DirectiveReplace string = "REPLACE"
+)
+
+// ObjectAttributes are the user-specified attributes of an object, which are
+// set when it's written and returned when it's read.
+type ObjectAttributes struct {
+	// ContentType is the value of the `Content-Type` header
+	ContentType string
+	// ContentEncoding is the value of the `Content-Encoding` header
+	ContentEncoding string
+	// ContentDisposition is the value of the `Content-Disposition` header
+	ContentDisposition string
+	// ContentLanguage is the value of the `Content-Language` header
+	ContentLanguage string
+	// CacheControl is the value of the `Cache-Control` header
+	CacheControl string
+	// Expires is the value of the `Expires` header
+	Expires string
+	// StorageClass specifies the storage class used for the object, or an
+	// empty string if storage classes are not supported.
+	StorageClass string
+	// Metadata is the user-defined metadata of the object, keyed by
+	// lowercase name without the `x-amz-meta-` prefix.
+	Metadata map[string]string
+	// Tags are the tags set on the object
+	Tags map[string]string
+	// ACL is the canned ACL set on the object (e.g. `private` or
+	// `public-read`), or an empty string if it's unspecified.
+	ACL string
+	// ServerSideEncryption specifies the server-side encryption algorithm
+	// used for the object (e.g. `AES256` or `aws:kms`), or an empty string if
+	// the object is not encrypted.
+	ServerSideEncryption string
+	// SSEKMSKeyID specifies the ID of the KMS key used to encrypt the
+	// object, if `ServerSideEncryption` is `aws:kms`.
+	SSEKMSKeyID string
+	// Checksum is the additional checksum of the object, or nil if none was
+	// specified. On writes, the checksum has already been validated against
+	// the object contents, except for streaming uploads: those are validated
+	// as the contents are read, and trailing checksums only have their value
+	// set once the reader has returned `io.EOF`.
+	Checksum *Checksum
+}
+
 // GetObjectResult is a response from a GetObject call
 type GetObjectResult struct {
 	// ETag is a hex encoding of the hash of the object contents, with or
@@ -25,10 +79,41 @@ type GetObjectResult struct {
 	DeleteMarker bool
 	// ModTime specifies when the object was modified.
 	ModTime time.Time
+	// Attributes are the user-specified attributes of the object, or nil if
+	// they're not supported.
+	Attributes *ObjectAttributes
 	// Content is the contents of the object.
 	Content io.ReadSeeker
 }
 
+// HeadObjectResult is a response from a HeadObject call
+type HeadObjectResult struct {
+	// ETag is a hex encoding of the hash of the object contents, with or
+	// without surrounding quotes.
+	ETag string
+	// Version is the version of the object, or an empty string if versioning
+	// is not enabled or supported.
+	Version string
+	// DeleteMarker specifies whether there's a delete marker in place of the
+	// object.
+	DeleteMarker bool
+	// ModTime specifies when the object was modified.
+	ModTime time.Time
+	// Size specifies the size of the object
+	Size uint64
+	// Attributes are the user-specified attributes of the object, or nil if
+	// they're not supported.
+	Attributes *ObjectAttributes
+	// ObjectLockMode specifies the object lock mode (`GOVERNANCE` or
+	// `COMPLIANCE`), or an empty string if the object is not locked.
+	ObjectLockMode string
+	// ObjectLockRetainUntilDate specifies when the object lock expires.
+	ObjectLockRetainUntilDate time.Time
+	// ObjectLockLegalHold specifies whether a legal hold is in place on the
+	// object.
+	ObjectLockLegalHold bool
+}
+
 // PutObjectResult is a response from a PutObject call
 type PutObjectResult struct {
 	// ETag is a hex encoding of the hash of the object contents, with or
@@ -39,6 +124,56 @@ type PutObjectResult struct {
 	Version string
 }
 
+// CopyObjectResult is a response from a CopyObject call
+type CopyObjectResult struct {
+	// ETag is a hex encoding of the hash of the destination object's
+	// contents, with or without surrounding quotes.
+	ETag string
+	// Version is the version of the destination object, or an empty string
+	// if versioning is not enabled or supported.
+	Version string
+	// ModTime specifies when the destination object was modified.
+	ModTime time.Time
+}
+
+// WritePrecondition specifies a condition that must hold for a write to an
+// object to succeed. Controllers should evaluate it atomically with the
+// write, e.g. via `Check`, to provide create-only and compare-and-swap
+// semantics. If the condition cannot be evaluated because of a concurrent
+// conflicting write, controllers should return
+// `ConditionalRequestConflictError`.
+type WritePrecondition struct {
+	// IfNoneMatch specifies that the write should only succeed if the object
+	// does not already exist, i.e. `If-None-Match: *` was set.
+	IfNoneMatch bool
+	// IfMatch is the value of the `If-Match` header, or an empty string if
+	// it was not set. If set, the write should only succeed if the object
+	// exists with a matching ETag.
+	IfMatch string
+}
+
+// Check evaluates the precondition against the current state of the object.
+// `exists` specifies whether the object currently exists (delete markers
+// should be considered non-existent), and `etag` is the object's current
+// ETag. A nil precondition always holds.
+func (p *WritePrecondition) Check(r *http.Request, exists bool, etag string) error {
+	if p == nil {
+		return nil
+	}
+	if p.IfNoneMatch && exists {
+		return PreconditionFailedError(r)
+	}
+	if p.IfMatch != "" {
+		if !exists {
+			return NoSuchKeyError(r)
+		}
+		if !checkIfMatch(p.IfMatch, addETagQuotes(etag)) {
+			return PreconditionFailedError(r)
+		}
+	}
+	return nil
+}
+
 // DeleteObjectResult is a response from a DeleteObject call
 type DeleteObjectResult struct {
 	// Version is the version of the object, or an empty string if versioning
@@ -49,6 +184,15 @@ type DeleteObjectResult struct {
 	DeleteMarker bool
 }
 
+// ObjectPart specifies the boundaries of a part of an object that was created
+// via a multipart upload
+type ObjectPart struct {
+	// PartNumber is the index of the part
+	PartNumber int
+	// Size specifies the size of the part
+	Size uint64
+}
+
 // ObjectController is an interface that specifies object-level functionality.
 type ObjectController interface {
 	// GetObject gets an object
@@ -61,6 +205,79 @@ type ObjectController interface {
 	DeleteObject(r *http.Request, bucket, key, version string) (*DeleteObjectResult, error)
 }
 
+// ObjectAttributesController is an optional interface that an
+// `ObjectController` can implement to persist the attributes of written
+// objects, evaluate write preconditions and report the results of copies.
+// If implemented, its methods are called instead of `PutObject` and
+// `CopyObject`. If it's not implemented, attributes are dropped, and writes
+// with preconditions are rejected with `NotImplementedError`.
+type ObjectAttributesController interface {
+	// PutObjectWithAttributes sets an object with the given attributes. If
+	// `precondition` is non-nil, the object should only be written if it
+	// holds.
+	PutObjectWithAttributes(r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error)
+	// CopyObjectWithAttributes copies an object. `attrs` are the attributes
+	// the destination object should have, resolved from the source object
+	// and the request's metadata and tagging directives.
+	CopyObjectWithAttributes(r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error)
+}
+
+// ObjectPartsController is an optional interface that an `ObjectController`
+// can implement to serve requests for individual parts of objects that were
+// created via multipart uploads, i.e. GET and HEAD requests with a
+// `partNumber` query parameter. If it's not implemented, every object is
+// treated as consisting of a single part.
+type ObjectPartsController interface {
+	// GetObjectParts gets the parts an object was created from, in
+	// ascending order of part number. If the object was not created via a
+	// multipart upload, an empty list should be returned.
+	GetObjectParts(r *http.Request, bucket, key, version string) ([]*ObjectPart, error)
+}
+
+// HeadObjectController is an optional interface that an `ObjectController`
+// can implement to serve HEAD requests, and to check copy preconditions,
+// without fetching object contents. If it's not implemented, `GetObject` is
+// used instead.
+type HeadObjectController interface {
+	// HeadObject gets an object's metadata
+	HeadObject(r *http.Request, bucket, key, version string) (*HeadObjectResult, error)
+}
+
+// ObjectContextController is a context-aware variant of
+// `ObjectController`, which also includes the methods of
+// `ObjectAttributesController`
+type ObjectContextController interface {
+	// GetObject gets an object
+	GetObject(ctx context.Context, r *http.Request, bucket, key, version string) (*GetObjectResult, error)
+	// CopyObject copies an object. `attrs` are the attributes the
+	// destination object should have, resolved from the source object and
+	// the request's metadata and tagging directives.
+	CopyObject(ctx context.Context, r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error)
+	// PutObject sets an object with the given attributes. If `precondition`
+	// is non-nil, the object should only be written if it holds.
+	PutObject(ctx context.Context, r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error)
+	// DeleteObject deletes an object
+	DeleteObject(ctx context.Context, r *http.Request, bucket, key, version string) (*DeleteObjectResult, error)
+}
+
+// ObjectPartsContextController is a context-aware variant of
+// `ObjectPartsController`, which an `ObjectContextController` can
+// optionally implement
+type ObjectPartsContextController interface {
+	// GetObjectParts gets the parts an object was created from, in
+	// ascending order of part number. If the object was not created via a
+	// multipart upload, an empty list should be returned.
+	GetObjectParts(ctx context.Context, r *http.Request, bucket, key, version string) ([]*ObjectPart, error)
+}
+
+// HeadObjectContextController is a context-aware variant of
+// `HeadObjectController`, which an `ObjectContextController` can optionally
+// implement
+type HeadObjectContextController interface {
+	// HeadObject gets an object's metadata
+	HeadObject(ctx context.Context, r *http.Request, bucket, key, version string) (*HeadObjectResult, error)
+}
+
 // unimplementedObjectController defines a controller that returns
 // `NotImplementedError` for all functionality
 type unimplementedObjectController struct{}
@@ -82,8 +299,11 @@ func (c unimplementedObjectController) DeleteObject(r *http.Request, bucket, key
 }
 
 type objectHandler struct {
-	controller ObjectController
-	logger     *logrus.Entry
+	controller      ObjectContextController
+	partsController ObjectPartsContextController
+	headController  HeadObjectContextController
+	quota           QuotaController
+	logger          *logrus.Entry
 }
 
 func (h *objectHandler) get(w http.ResponseWriter, r *http.Request) {
@@ -92,7 +312,13 @@ func (h *objectHandler) get(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 	versionId := r.FormValue("versionId")
 
-	result, err := h.controller.GetObject(r, bucket, key, versionId)
+	partNumber, err := partNumberFormValue(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	result, err := h.controller.GetObject(r.Context(), r, bucket, key, versionId)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
@@ -111,101 +337,356 @@ func (h *objectHandler) get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notModified, err := checkGetConditions(r, result.ETag, result.ModTime)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	if notModified {
+		writeNotModified(w, result.ModTime)
+		return
+	}
+	stripGetConditions(r)
+
+	writeAttributeHeaders(w, result.Attributes)
+	writeChecksumModeHeaders(w, r, result.Attributes, partNumber)
+
+	if partNumber > 0 {
+		h.getPart(w, r, bucket, key, result, partNumber)
+		return
+	}
+
 	http.ServeContent(w, r, key, result.ModTime, result.Content)
 }
 
-func (h *objectHandler) copy(w http.ResponseWriter, r *http.Request) {
+func (h *objectHandler) head(w http.ResponseWriter, r *http.Request) {
+	if h.headController == nil {
+		h.get(w, r)
+		return
+	}
+
 	vars := mux.Vars(r)
-	destBucket := vars["bucket"]
-	destKey := vars["key"]
+	bucket := vars["bucket"]
+	key := vars["key"]
+	versionId := r.FormValue("versionId")
 
-	var srcBucket string
-	var srcKey string
-	srcURL, err := url.Parse(r.Header.Get("x-amz-copy-source"))
+	partNumber, err := partNumberFormValue(r)
 	if err != nil {
-		WriteError(h.logger, w, r, InvalidArgumentError(r))
+		WriteError(h.logger, w, r, err)
 		return
 	}
-	srcPath := strings.SplitN(srcURL.Path, "/", 3)
-	if len(srcPath) == 2 {
-		srcBucket = srcPath[0]
-		srcKey = srcPath[1]
-	} else if len(srcPath) == 3 {
-		if srcPath[0] != "" {
-			WriteError(h.logger, w, r, InvalidArgumentError(r))
+
+	result, err := h.headController.HeadObject(r.Context(), r, bucket, key, versionId)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	if result.ETag != "" {
+		w.Header().Set("ETag", addETagQuotes(result.ETag))
+	}
+	if result.Version != "" {
+		w.Header().Set("x-amz-version-id", result.Version)
+	}
+
+	if result.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		WriteError(h.logger, w, r, NoSuchKeyError(r))
+		return
+	}
+
+	notModified, err := checkGetConditions(r, result.ETag, result.ModTime)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	if notModified {
+		writeNotModified(w, result.ModTime)
+		return
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	writeAttributeHeaders(w, result.Attributes)
+	writeChecksumModeHeaders(w, r, result.Attributes, partNumber)
+	writeHeadHeaders(w, result)
+	if !isZeroTime(result.ModTime) {
+		w.Header().Set("Last-Modified", result.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if partNumber > 0 {
+		parts, err := h.objectParts(r, bucket, key, result.Version)
+		if err != nil {
+			WriteError(h.logger, w, r, err)
 			return
 		}
-		srcBucket = srcPath[1]
-		srcKey = srcPath[2]
-	} else {
-		WriteError(h.logger, w, r, InvalidArgumentError(r))
+
+		offset, length, err := partRange(r, parts, result.Size, partNumber)
+		if err != nil {
+			WriteError(h.logger, w, r, err)
+			return
+		}
+
+		writePartHeaders(w, parts, offset, length, result.Size)
 		return
 	}
-	srcVersionID := srcURL.Query().Get("versionId")
 
-	if srcBucket == "" {
-		WriteError(h.logger, w, r, InvalidBucketNameError(r))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatUint(result.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeNotModified writes a `304 Not Modified` response. The ETag header is
+// expected to already be set.
+func writeNotModified(w http.ResponseWriter, modTime time.Time) {
+	if !isZeroTime(modTime) {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// writeAttributeHeaders writes the headers for an object's user-specified
+// attributes
+func writeAttributeHeaders(w http.ResponseWriter, attrs *ObjectAttributes) {
+	if attrs == nil {
 		return
 	}
-	if srcKey == "" {
-		WriteError(h.logger, w, r, NoSuchKeyError(r))
+
+	headers := map[string]string{
+		"Content-Type":        attrs.ContentType,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Language":    attrs.ContentLanguage,
+		"Cache-Control":       attrs.CacheControl,
+		"Expires":             attrs.Expires,
+		"x-amz-storage-class": attrs.StorageClass,
+	}
+	for name, value := range headers {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	for name, value := range attrs.Metadata {
+		w.Header().Set("x-amz-meta-"+name, value)
+	}
+	if len(attrs.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(attrs.Tags)))
+	}
+	if attrs.ServerSideEncryption != "" {
+		w.Header().Set("x-amz-server-side-encryption", attrs.ServerSideEncryption)
+		if attrs.SSEKMSKeyID != "" {
+			w.Header().Set("x-amz-server-side-encryption-aws-kms-key-id", attrs.SSEKMSKeyID)
+		}
+	}
+}
+
+// writeChecksumModeHeaders writes the headers for an object's additional
+// checksum, if it was requested via `x-amz-checksum-mode`. Checksums only
+// apply to whole objects, so they're not returned for ranges or parts.
+func writeChecksumModeHeaders(w http.ResponseWriter, r *http.Request, attrs *ObjectAttributes, partNumber int) {
+	if attrs == nil || partNumber > 0 || r.Header.Get("Range") != "" {
 		return
 	}
-	if srcBucket == destBucket && srcKey == destKey && srcVersionID == "" {
-		// If we ever add support for object metadata, this error should not
-		// trigger in the case where metadata is changed, since it is a valid
-		// way to alter the metadata of an object
-		WriteError(h.logger, w, r, InvalidRequestError(r, "source and destination are the same"))
+	if !strings.EqualFold(r.Header.Get("x-amz-checksum-mode"), "ENABLED") {
+		return
+	}
+	writeChecksumHeaders(w, attrs.Checksum)
+}
+
+// writeHeadHeaders writes the headers for the object lock state returned
+// from a HeadObject call
+func writeHeadHeaders(w http.ResponseWriter, result *HeadObjectResult) {
+	if result.ObjectLockMode != "" {
+		w.Header().Set("x-amz-object-lock-mode", result.ObjectLockMode)
+		w.Header().Set("x-amz-object-lock-retain-until-date", result.ObjectLockRetainUntilDate.UTC().Format(time.RFC3339))
+	}
+	if result.ObjectLockLegalHold {
+		w.Header().Set("x-amz-object-lock-legal-hold", "ON")
+	}
+}
+
+// getPart serves a single part of an object
+func (h *objectHandler) getPart(w http.ResponseWriter, r *http.Request, bucket, key string, result *GetObjectResult, partNumber int) {
+	size, err := result.Content.Seek(0, io.SeekEnd)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
 		return
 	}
 
-	ifMatch := r.Header.Get("x-amz-copy-source-if-match")
-	ifNoneMatch := r.Header.Get("x-amz-copy-source-if-none-match")
-	ifUnmodifiedSince := r.Header.Get("x-amz-copy-source-if-unmodified-since")
-	ifModifiedSince := r.Header.Get("x-amz-copy-source-if-modified-since")
+	parts, err := h.objectParts(r, bucket, key, result.Version)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
 
-	getResult, err := h.controller.GetObject(r, srcBucket, srcKey, srcVersionID)
+	offset, length, err := partRange(r, parts, uint64(size), partNumber)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
-	if getResult.DeleteMarker {
-		WriteError(h.logger, w, r, NoSuchKeyError(r))
+
+	if _, err := result.Content.Seek(int64(offset), io.SeekStart); err != nil {
+		WriteError(h.logger, w, r, err)
 		return
 	}
 
-	if !checkIfMatch(ifMatch, getResult.ETag) {
-		WriteError(h.logger, w, r, PreconditionFailedError(r))
+	// as with `http.ServeContent`, an explicit content type from the
+	// object's attributes takes precedence
+	if w.Header().Get("Content-Type") == "" {
+		if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+	if !isZeroTime(result.ModTime) {
+		w.Header().Set("Last-Modified", result.ModTime.UTC().Format(http.TimeFormat))
+	}
+	writePartHeaders(w, parts, offset, length, uint64(size))
+
+	if r.Method != "HEAD" {
+		if _, err := io.CopyN(w, result.Content, int64(length)); err != nil {
+			// just log a message since a response has already been partially
+			// written
+			h.logger.Errorf("could not write object part: %v", err)
+		}
+	}
+}
+
+// partNumberFormValue extracts the `partNumber` query parameter used to
+// request a single part of an object. If it's unspecified, 0 is returned.
+func partNumberFormValue(r *http.Request) (int, error) {
+	partNumber, err := intFormValue(r, "partNumber", 1, maxPartsAllowed, 0)
+	if err != nil {
+		return 0, err
+	}
+	if partNumber > 0 && r.Header.Get("Range") != "" {
+		return 0, InvalidRequestError(r, "Cannot specify both Range header and partNumber query parameter")
+	}
+	return partNumber, nil
+}
+
+// objectParts gets the parts of an object if the controller supports it,
+// and otherwise returns an empty list
+func (h *objectHandler) objectParts(r *http.Request, bucket, key, version string) ([]*ObjectPart, error) {
+	if h.partsController == nil {
+		return nil, nil
+	}
+	return h.partsController.GetObjectParts(r.Context(), r, bucket, key, version)
+}
+
+// partRange calculates the byte offset and length of the given part of an
+// object. If the object was not created via a multipart upload (i.e. `parts`
+// is empty), the entire object is considered to be part 1.
+func partRange(r *http.Request, parts []*ObjectPart, size uint64, partNumber int) (uint64, uint64, error) {
+	if len(parts) == 0 {
+		if partNumber != 1 {
+			return 0, 0, InvalidPartNumberError(r)
+		}
+		return 0, size, nil
+	}
+
+	var offset, total uint64
+	var found *ObjectPart
+	for _, part := range parts {
+		if part.PartNumber == partNumber {
+			found = part
+			offset = total
+		}
+		total += part.Size
+	}
+	if total != size {
+		return 0, 0, InternalError(r, fmt.Errorf("object parts total %d bytes, but the object is %d bytes", total, size))
+	}
+	if found == nil {
+		return 0, 0, InvalidPartNumberError(r)
+	}
+	return offset, found.Size, nil
+}
+
+// writePartHeaders writes the headers and status code for a response serving
+// a single part of an object
+func writePartHeaders(w http.ResponseWriter, parts []*ObjectPart, offset, length, size uint64) {
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatUint(length, 10))
+	if length > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	}
+	if len(parts) > 0 {
+		w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(len(parts)))
+	}
+	w.WriteHeader(http.StatusPartialContent)
+}
+
+func (h *objectHandler) copy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	destBucket := vars["bucket"]
+	destKey := vars["key"]
+
+	srcBucket, srcKey, srcVersionID, err := copySourceHeader(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
 		return
 	}
 
-	if !checkIfNoneMatch(ifNoneMatch, getResult.ETag) {
-		WriteError(h.logger, w, r, PreconditionFailedError(r))
+	metadataDirective, err := directiveHeader(r, "x-amz-metadata-directive")
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	taggingDirective, err := directiveHeader(r, "x-amz-tagging-directive")
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	requestAttrs, err := objectAttributesFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
 		return
 	}
 
-	if !checkIfUnmodifiedSince(ifUnmodifiedSince, getResult.ModTime) {
-		WriteError(h.logger, w, r, PreconditionFailedError(r))
+	// copying an object onto itself is only allowed when it changes the
+	// object's attributes
+	if srcBucket == destBucket && srcKey == destKey && srcVersionID == "" && metadataDirective != DirectiveReplace && taggingDirective != DirectiveReplace && requestAttrs.StorageClass == "" && requestAttrs.ServerSideEncryption == "" {
+		WriteError(h.logger, w, r, InvalidRequestError(r, "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes."))
 		return
 	}
 
-	if !checkIfModifiedSince(ifModifiedSince, getResult.ModTime) {
-		WriteError(h.logger, w, r, PreconditionFailedError(r))
+	getResult, err := getCopySource(r, h.controller, h.headController, srcBucket, srcKey, srcVersionID)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
 		return
 	}
 
-	destVersionID, err := h.controller.CopyObject(r, srcBucket, srcKey, getResult, destBucket, destKey)
+	attrs := copyObjectAttributes(getResult.Attributes, requestAttrs, metadataDirective, taggingDirective)
+
+	size, err := h.copySourceSize(getResult)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
+	if err := checkQuota(h.quota, r, QuotaOpCopyObject, destBucket, destKey, "", size); err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
+	result, err := h.controller.CopyObject(r.Context(), r, srcBucket, srcKey, getResult, destBucket, destKey, attrs)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpCopyObject, destBucket, destKey, "", result.Version, size)
 
 	if getResult.Version != "" {
 		w.Header().Set("x-amz-copy-source-version-id", getResult.Version)
 	}
 
-	if destVersionID != "" {
-		w.Header().Set("x-amz-version-id", srcVersionID)
+	if result.Version != "" {
+		w.Header().Set("x-amz-version-id", result.Version)
+	}
+
+	modTime := result.ModTime
+	if isZeroTime(modTime) {
+		modTime = time.Now()
 	}
 
 	marshallable := struct {
@@ -213,13 +694,116 @@ func (h *objectHandler) copy(w http.ResponseWriter, r *http.Request) {
 		LastModified time.Time `xml:"LastModified"`
 		ETag         string    `xml:"ETag"`
 	}{
-		LastModified: getResult.ModTime,
-		ETag:         getResult.ETag,
+		// some clients (e.g. minio-python) can't handle sub-seconds in
+		// datetime output
+		LastModified: modTime.UTC().Round(time.Second),
+		ETag:         addETagQuotes(result.ETag),
 	}
 
 	writeXML(h.logger, w, r, http.StatusOK, marshallable)
 }
 
+// getCopySource gets the source object of a copy, checking the copy source
+// preconditions against it. If any are specified and the controller supports
+// it, they're also checked beforehand via a HEAD, so that copies which fail
+// them don't fetch the object's contents.
+func getCopySource(r *http.Request, controller ObjectContextController, headController HeadObjectContextController, bucket, key, version string) (*GetObjectResult, error) {
+	if headController != nil && hasCopySourceConditions(r) {
+		headResult, err := headController.HeadObject(r.Context(), r, bucket, key, version)
+		if err != nil {
+			return nil, err
+		}
+		if headResult.DeleteMarker {
+			return nil, NoSuchKeyError(r)
+		}
+		if err := checkCopySourceConditions(r, headResult.ETag, headResult.ModTime); err != nil {
+			return nil, err
+		}
+	}
+
+	// the preconditions are always checked against the result that's
+	// copied, since the object may have changed since the HEAD
+	getResult, err := controller.GetObject(r.Context(), r, bucket, key, version)
+	if err != nil {
+		return nil, err
+	}
+	if getResult.DeleteMarker {
+		return nil, NoSuchKeyError(r)
+	}
+	if err := checkCopySourceConditions(r, getResult.ETag, getResult.ModTime); err != nil {
+		return nil, err
+	}
+	return getResult, nil
+}
+
+// copySourceSize gets the size of a copy's source object, which is charged
+// against the destination's quotas. The source object's contents are
+// rewound afterwards.
+func (h *objectHandler) copySourceSize(getResult *GetObjectResult) (int64, error) {
+	if h.quota == nil {
+		return -1, nil
+	}
+	size, err := getResult.Content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := getResult.Content.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// directiveHeader gets the value of a copy directive header, which must
+// either be `COPY` (the default) or `REPLACE`
+func directiveHeader(r *http.Request, name string) (string, error) {
+	directive := r.Header.Get(name)
+	switch directive {
+	case "":
+		return DirectiveCopy, nil
+	case DirectiveCopy, DirectiveReplace:
+		return directive, nil
+	default:
+		return "", InvalidArgumentError(r)
+	}
+}
+
+// copyObjectAttributes resolves the attributes of a copied object, based on
+// the source object's attributes (which may be nil), the attributes
+// specified in the copy request, and the request's directives. The storage
+// class is always taken from the request if it's specified, while the ACL
+// and encryption settings are never copied from the source object.
+func copyObjectAttributes(srcAttrs, requestAttrs *ObjectAttributes, metadataDirective, taggingDirective string) *ObjectAttributes {
+	attrs := ObjectAttributes{}
+	if metadataDirective == DirectiveReplace {
+		attrs = *requestAttrs
+	} else if srcAttrs != nil {
+		attrs = *srcAttrs
+		if requestAttrs.StorageClass != "" {
+			attrs.StorageClass = requestAttrs.StorageClass
+		}
+	}
+
+	if taggingDirective == DirectiveReplace {
+		attrs.Tags = requestAttrs.Tags
+	} else if srcAttrs != nil {
+		attrs.Tags = srcAttrs.Tags
+	} else {
+		attrs.Tags = nil
+	}
+
+	attrs.ACL = requestAttrs.ACL
+	attrs.ServerSideEncryption = requestAttrs.ServerSideEncryption
+	attrs.SSEKMSKeyID = requestAttrs.SSEKMSKeyID
+
+	// the contents are unchanged, so the checksum always carries over
+	attrs.Checksum = nil
+	if srcAttrs != nil {
+		attrs.Checksum = srcAttrs.Checksum
+	}
+
+	return &attrs
+}
+
 func (h *objectHandler) put(w http.ResponseWriter, r *http.Request) {
 	transferEncoding := r.Header["Transfer-Encoding"]
 	identity := false
@@ -235,32 +819,51 @@ func (h *objectHandler) put(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	precondition, err := writePreconditionFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	attrs, err := objectAttributesFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	attrs.Checksum, err = checksumFromRequest(r)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	key := vars["key"]
-	chunked := r.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
-
-	var body io.ReadCloser
-	if chunked {
-		signingKey := []byte(vars["authSignatureKey"])
-		seedSignature := vars["authSignature"]
-		timestamp := vars["authSignatureTimestamp"]
-		date := vars["authSignatureDate"]
-		region := vars["authSignatureRegion"]
-		body = newChunkedReader(r.Body, signingKey, seedSignature, timestamp, date, region)
-	} else {
-		body = r.Body
+
+	body, checksum, err := chunkedBody(r, attrs.Checksum)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	attrs.Checksum = checksum
+
+	// the quota was checked by `quotaMiddleware`, but if the size wasn't
+	// declared, it's reconciled with the number of bytes read
+	size := declaredSize(r)
+	var counter *countingReader
+	if size < 0 && h.quota != nil {
+		counter = &countingReader{ReadCloser: body}
+		body = counter
 	}
 
-	result, err := h.controller.PutObject(r, bucket, key, body)
+	result, err := h.controller.PutObject(r.Context(), r, bucket, key, body, attrs, precondition)
 	if err != nil {
-		if err == InvalidChunk {
-			WriteError(h.logger, w, r, SignatureDoesNotMatchError(r))
-		} else {
-			WriteError(h.logger, w, r, err)
-		}
+		WriteError(h.logger, w, r, chunkedReaderError(r, err))
 		return
 	}
+	if counter != nil {
+		size = counter.n
+	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpPutObject, bucket, key, "", result.Version, size)
 
 	if result.ETag != "" {
 		w.Header().Set("ETag", addETagQuotes(result.ETag))
@@ -268,6 +871,9 @@ func (h *objectHandler) put(w http.ResponseWriter, r *http.Request) {
 	if result.Version != "" {
 		w.Header().Set("x-amz-version-id", result.Version)
 	}
+	if attrs.Checksum != nil && attrs.Checksum.Value != "" {
+		w.Header().Set(checksumHeader(attrs.Checksum.Algorithm), attrs.Checksum.Value)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -277,11 +883,12 @@ func (h *objectHandler) del(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 	versionId := r.FormValue("versionId")
 
-	result, err := h.controller.DeleteObject(r, bucket, key, versionId)
+	result, err := h.controller.DeleteObject(r.Context(), r, bucket, key, versionId)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
 	}
+	reconcileQuota(h.logger, h.quota, r, QuotaOpDeleteObject, bucket, key, "", versionId, -1)
 
 	if result.Version != "" {
 		w.Header().Set("x-amz-version-id", result.Version)
@@ -337,7 +944,7 @@ func (h *objectHandler) post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, object := range payload.Objects {
-		result, err := h.controller.DeleteObject(r, bucket, object.Key, object.Version)
+		result, err := h.controller.DeleteObject(r.Context(), r, bucket, object.Key, object.Version)
 		if err != nil {
 			s3Err := newGenericError(r, err)
 
@@ -351,6 +958,8 @@ func (h *objectHandler) post(w http.ResponseWriter, r *http.Request) {
 				Message: s3Err.Message,
 			})
 		} else {
+			reconcileQuota(h.logger, h.quota, r, QuotaOpDeleteObject, bucket, object.Key, "", object.Version, -1)
+
 			deleteMarkerVersion := ""
 			if result.DeleteMarker {
 				deleteMarkerVersion = result.Version
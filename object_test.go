@@ -0,0 +1,586 @@
+package s2
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// copyTestController is an `ObjectController` that serves a single source
+// object, and returns a fixed result for copies of it
+type copyTestController struct {
+	unimplementedObjectController
+	versioned bool
+}
+
+func (c *copyTestController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	if bucket != "src" || key != "obj" {
+		return nil, NoSuchKeyError(r)
+	}
+
+	result := GetObjectResult{
+		ETag:    "srcetag",
+		ModTime: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Content: bytes.NewReader([]byte("content")),
+	}
+	if c.versioned {
+		result.Version = "srcversion"
+	}
+	return &result, nil
+}
+
+func (c *copyTestController) PutObjectWithAttributes(r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	return nil, NotImplementedError(r)
+}
+
+func (c *copyTestController) CopyObjectWithAttributes(r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error) {
+	result := CopyObjectResult{
+		ETag:    "destetag",
+		ModTime: time.Date(2020, 2, 2, 2, 2, 2, 500, time.UTC),
+	}
+	if c.versioned {
+		result.Version = "destversion"
+	}
+	return &result, nil
+}
+
+func TestCopyObjectResult(t *testing.T) {
+	tests := []struct {
+		name              string
+		versioned         bool
+		copySource        string
+		srcVersionHeader  string
+		destVersionHeader string
+	}{
+		{
+			name:       "unversioned",
+			versioned:  false,
+			copySource: "/src/obj",
+		},
+		{
+			name:              "versioned",
+			versioned:         true,
+			copySource:        "/src/obj",
+			srcVersionHeader:  "srcversion",
+			destVersionHeader: "destversion",
+		},
+		{
+			name:              "versioned with source version",
+			versioned:         true,
+			copySource:        "/src/obj?versionId=srcversion",
+			srcVersionHeader:  "srcversion",
+			destVersionHeader: "destversion",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+			s.Object = &copyTestController{versioned: test.versioned}
+
+			req := httptest.NewRequest("PUT", "/dest/obj", nil)
+			req.Header.Set("x-amz-copy-source", test.copySource)
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+			if v := rec.Header().Get("x-amz-copy-source-version-id"); v != test.srcVersionHeader {
+				t.Errorf("unexpected source version header: %q", v)
+			}
+			if v := rec.Header().Get("x-amz-version-id"); v != test.destVersionHeader {
+				t.Errorf("unexpected version header: %q", v)
+			}
+
+			result := struct {
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+			}{}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("could not unmarshal response: %v", err)
+			}
+			if result.ETag != `"destetag"` {
+				t.Errorf("unexpected etag: %q", result.ETag)
+			}
+			expectedModTime := time.Date(2020, 2, 2, 2, 2, 2, 0, time.UTC)
+			if !result.LastModified.Equal(expectedModTime) {
+				t.Errorf("unexpected last modified time: %v", result.LastModified)
+			}
+		})
+	}
+}
+
+// partsTestController is an `ObjectController` that serves `multi.txt`, an
+// object created from two parts, and `single.txt`, an object that wasn't
+// created via a multipart upload
+type partsTestController struct {
+	unimplementedObjectController
+}
+
+func (c partsTestController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	return &GetObjectResult{
+		ETag:    "etag",
+		ModTime: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Content: bytes.NewReader([]byte("hello world")),
+	}, nil
+}
+
+func (c partsTestController) GetObjectParts(r *http.Request, bucket, key, version string) ([]*ObjectPart, error) {
+	if key == "multi.txt" {
+		return []*ObjectPart{{PartNumber: 1, Size: 5}, {PartNumber: 2, Size: 6}}, nil
+	}
+	return nil, nil
+}
+
+// partsTestHeadController is a `partsTestController` that also implements
+// `HeadObjectController`
+type partsTestHeadController struct {
+	partsTestController
+}
+
+func (c partsTestHeadController) HeadObject(r *http.Request, bucket, key, version string) (*HeadObjectResult, error) {
+	return &HeadObjectResult{
+		ETag:    "etag",
+		ModTime: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Size:    11,
+	}, nil
+}
+
+func TestObjectParts(t *testing.T) {
+	tests := []struct {
+		target       string
+		code         int
+		contentRange string
+		partsCount   string
+		body         string
+	}{
+		{target: "/bucket/multi.txt?partNumber=1", code: http.StatusPartialContent, contentRange: "bytes 0-4/11", partsCount: "2", body: "hello"},
+		{target: "/bucket/multi.txt?partNumber=2", code: http.StatusPartialContent, contentRange: "bytes 5-10/11", partsCount: "2", body: " world"},
+		{target: "/bucket/multi.txt?partNumber=3", code: http.StatusRequestedRangeNotSatisfiable},
+		{target: "/bucket/multi.txt?partNumber=0", code: http.StatusBadRequest},
+		{target: "/bucket/multi.txt?partNumber=10001", code: http.StatusBadRequest},
+		{target: "/bucket/single.txt?partNumber=1", code: http.StatusPartialContent, contentRange: "bytes 0-10/11", body: "hello world"},
+		{target: "/bucket/single.txt?partNumber=2", code: http.StatusRequestedRangeNotSatisfiable},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	controllers := map[string]ObjectController{
+		"get":  partsTestController{},
+		"head": partsTestHeadController{},
+	}
+	for name, controller := range controllers {
+		s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+		s.Object = controller
+		router := s.Router()
+
+		for _, test := range tests {
+			for _, method := range []string{"GET", "HEAD"} {
+				t.Run(name+" "+method+" "+test.target, func(t *testing.T) {
+					rec := httptest.NewRecorder()
+					router.ServeHTTP(rec, httptest.NewRequest(method, test.target, nil))
+					if rec.Code != test.code {
+						t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+					}
+					if test.code != http.StatusPartialContent {
+						return
+					}
+
+					if v := rec.Header().Get("Content-Range"); v != test.contentRange {
+						t.Errorf("unexpected content range: %q", v)
+					}
+					if v := rec.Header().Get("x-amz-mp-parts-count"); v != test.partsCount {
+						t.Errorf("unexpected parts count: %q", v)
+					}
+					if v := rec.Header().Get("Content-Type"); !strings.HasPrefix(v, "text/plain") {
+						t.Errorf("unexpected content type: %q", v)
+					}
+					if v := rec.Header().Get("Content-Length"); v != strconv.Itoa(len(test.body)) {
+						t.Errorf("unexpected content length: %q", v)
+					}
+					if method == "GET" && rec.Body.String() != test.body {
+						t.Errorf("unexpected body: %q", rec.Body.String())
+					}
+				})
+			}
+		}
+	}
+}
+
+// headTestController is an `ObjectController` that implements
+// `HeadObjectController`, and counts the calls made to it. Its HEAD and GET
+// results have different ETags, as if the object changed in between.
+type headTestController struct {
+	unimplementedObjectController
+	gets, heads int
+}
+
+func (c *headTestController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	c.gets++
+	return &GetObjectResult{ETag: "get", Content: bytes.NewReader([]byte("content"))}, nil
+}
+
+func (c *headTestController) HeadObject(r *http.Request, bucket, key, version string) (*HeadObjectResult, error) {
+	c.heads++
+	return &HeadObjectResult{ETag: "head", Size: 7}, nil
+}
+
+func (c *headTestController) CopyObject(r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string) (string, error) {
+	return "", nil
+}
+
+func TestHeadObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		code    int
+		etag    string
+		gets    int
+		heads   int
+	}{
+		{name: "head", method: "HEAD", target: "/bucket/obj", code: http.StatusOK, etag: `"head"`, heads: 1},
+		{name: "head part", method: "HEAD", target: "/bucket/obj?partNumber=1", code: http.StatusPartialContent, etag: `"head"`, heads: 1},
+		{name: "get", method: "GET", target: "/bucket/obj", code: http.StatusOK, etag: `"get"`, gets: 1},
+		{name: "unconditional copy", method: "PUT", target: "/bucket/dest", headers: map[string]string{"x-amz-copy-source": "/bucket/obj"}, code: http.StatusOK, gets: 1},
+		{name: "copy failing head", method: "PUT", target: "/bucket/dest", headers: map[string]string{"x-amz-copy-source": "/bucket/obj", "x-amz-copy-source-if-match": `"get"`}, code: http.StatusPreconditionFailed, heads: 1},
+		{name: "copy failing get", method: "PUT", target: "/bucket/dest", headers: map[string]string{"x-amz-copy-source": "/bucket/obj", "x-amz-copy-source-if-match": `"head"`}, code: http.StatusPreconditionFailed, gets: 1, heads: 1},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := &headTestController{}
+			s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+			s.Object = controller
+
+			r := httptest.NewRequest(test.method, test.target, nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+			}
+			if v := rec.Header().Get("ETag"); test.etag != "" && v != test.etag {
+				t.Errorf("unexpected etag: %q", v)
+			}
+			if controller.gets != test.gets || controller.heads != test.heads {
+				t.Errorf("expected %d gets and %d heads, got %d gets and %d heads", test.gets, test.heads, controller.gets, controller.heads)
+			}
+		})
+	}
+}
+
+func TestGetConditions(t *testing.T) {
+	// the objects served by `partsTestController` were modified at
+	// 2019-01-01
+	before := "Mon, 31 Dec 2018 00:00:00 GMT"
+	after := "Wed, 02 Jan 2019 00:00:00 GMT"
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{name: "none", code: http.StatusOK},
+		{name: "if-match", headers: map[string]string{"If-Match": `"etag"`}, code: http.StatusOK},
+		{name: "if-match wildcard", headers: map[string]string{"If-Match": "*"}, code: http.StatusOK},
+		{name: "failing if-match", headers: map[string]string{"If-Match": `"other"`}, code: http.StatusPreconditionFailed},
+		{name: "failing if-unmodified-since", headers: map[string]string{"If-Unmodified-Since": before}, code: http.StatusPreconditionFailed},
+		{name: "if-match overrides if-unmodified-since", headers: map[string]string{"If-Match": `"etag"`, "If-Unmodified-Since": before}, code: http.StatusOK},
+		{name: "failing if-match with if-unmodified-since", headers: map[string]string{"If-Match": `"other"`, "If-Unmodified-Since": after}, code: http.StatusPreconditionFailed},
+		{name: "if-none-match", headers: map[string]string{"If-None-Match": `"other"`}, code: http.StatusOK},
+		{name: "failing if-none-match", headers: map[string]string{"If-None-Match": `"etag"`}, code: http.StatusNotModified},
+		{name: "failing if-modified-since", headers: map[string]string{"If-Modified-Since": after}, code: http.StatusNotModified},
+		{name: "if-modified-since", headers: map[string]string{"If-Modified-Since": before}, code: http.StatusOK},
+		{name: "if-none-match overrides if-modified-since", headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, code: http.StatusOK},
+		{name: "failing if-none-match with if-modified-since", headers: map[string]string{"If-None-Match": `"etag"`, "If-Modified-Since": before}, code: http.StatusNotModified},
+		{name: "if-match takes precedence over if-none-match", headers: map[string]string{"If-Match": `"other"`, "If-None-Match": `"etag"`}, code: http.StatusPreconditionFailed},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	controllers := map[string]ObjectController{
+		"get":  partsTestController{},
+		"head": partsTestHeadController{},
+	}
+	for name, controller := range controllers {
+		s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+		s.Object = controller
+		router := s.Router()
+
+		for _, test := range tests {
+			for _, method := range []string{"GET", "HEAD"} {
+				t.Run(name+" "+method+" "+test.name, func(t *testing.T) {
+					r := httptest.NewRequest(method, "/bucket/single.txt", nil)
+					for name, value := range test.headers {
+						r.Header.Set(name, value)
+					}
+					rec := httptest.NewRecorder()
+					router.ServeHTTP(rec, r)
+					if rec.Code != test.code {
+						t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+					}
+					if rec.Code == http.StatusNotModified {
+						if v := rec.Header().Get("ETag"); v != `"etag"` {
+							t.Errorf("unexpected etag: %q", v)
+						}
+						if rec.Body.Len() != 0 {
+							t.Errorf("unexpected body: %q", rec.Body.String())
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// preconditionTestController is an `ObjectController` and
+// `MultipartController` that stores the ETags of the objects written to it,
+// evaluating write preconditions against them. The ETag of a put object is
+// its contents.
+type preconditionTestController struct {
+	unimplementedObjectController
+	unimplementedMultipartController
+	etags map[string]string
+}
+
+func (c *preconditionTestController) PutObjectWithAttributes(r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	etag, exists := c.etags[key]
+	if err := precondition.Check(r, exists, etag); err != nil {
+		return nil, err
+	}
+	c.etags[key] = string(content)
+	return &PutObjectResult{ETag: string(content)}, nil
+}
+
+func (c *preconditionTestController) CopyObjectWithAttributes(r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error) {
+	return nil, NotImplementedError(r)
+}
+
+func (c *preconditionTestController) InitMultipartWithAttributes(r *http.Request, bucket, key string, attrs *ObjectAttributes) (string, error) {
+	return "", NotImplementedError(r)
+}
+
+func (c *preconditionTestController) CompleteMultipartWithAttributes(r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error) {
+	etag, exists := c.etags[key]
+	if err := precondition.Check(r, exists, etag); err != nil {
+		return nil, err
+	}
+	c.etags[key] = "multipart"
+	return &CompleteMultipartResult{ETag: "multipart"}, nil
+}
+
+func (c *preconditionTestController) UploadMultipartChunkWithChecksum(r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader, checksum *Checksum) (string, error) {
+	return "", NotImplementedError(r)
+}
+
+func TestWritePreconditions(t *testing.T) {
+	complete := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"part"</ETag></Part></CompleteMultipartUpload>`
+
+	// the steps are run in order against the same controller
+	steps := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		headers map[string]string
+		code    int
+	}{
+		{name: "put if-match missing", method: "PUT", target: "/bucket/a", body: "1", headers: map[string]string{"If-Match": `"1"`}, code: http.StatusNotFound},
+		{name: "put if-none-match missing", method: "PUT", target: "/bucket/a", body: "1", headers: map[string]string{"If-None-Match": "*"}, code: http.StatusOK},
+		{name: "put if-none-match existing", method: "PUT", target: "/bucket/a", body: "2", headers: map[string]string{"If-None-Match": "*"}, code: http.StatusPreconditionFailed},
+		{name: "put failing if-match", method: "PUT", target: "/bucket/a", body: "2", headers: map[string]string{"If-Match": `"2"`}, code: http.StatusPreconditionFailed},
+		{name: "put if-match", method: "PUT", target: "/bucket/a", body: "2", headers: map[string]string{"If-Match": `"1"`}, code: http.StatusOK},
+		{name: "put both", method: "PUT", target: "/bucket/a", body: "3", headers: map[string]string{"If-Match": `"2"`, "If-None-Match": "*"}, code: http.StatusBadRequest},
+		{name: "put if-none-match etag", method: "PUT", target: "/bucket/a", body: "3", headers: map[string]string{"If-None-Match": `"2"`}, code: http.StatusNotImplemented},
+		{name: "complete if-none-match existing", method: "POST", target: "/bucket/a?uploadId=upload", body: complete, headers: map[string]string{"If-None-Match": "*"}, code: http.StatusPreconditionFailed},
+		{name: "complete failing if-match", method: "POST", target: "/bucket/a?uploadId=upload", body: complete, headers: map[string]string{"If-Match": `"1"`}, code: http.StatusPreconditionFailed},
+		{name: "complete if-match", method: "POST", target: "/bucket/a?uploadId=upload", body: complete, headers: map[string]string{"If-Match": `"2"`}, code: http.StatusOK},
+		{name: "complete if-match missing", method: "POST", target: "/bucket/b?uploadId=upload", body: complete, headers: map[string]string{"If-Match": `"2"`}, code: http.StatusNotFound},
+		{name: "complete if-none-match missing", method: "POST", target: "/bucket/b?uploadId=upload", body: complete, headers: map[string]string{"If-None-Match": "*"}, code: http.StatusOK},
+		{name: "complete if-none-match existing after complete", method: "POST", target: "/bucket/b?uploadId=upload", body: complete, headers: map[string]string{"If-None-Match": "*"}, code: http.StatusPreconditionFailed},
+		{name: "put if-match after complete", method: "PUT", target: "/bucket/b", body: "3", headers: map[string]string{"If-Match": `"multipart"`}, code: http.StatusOK},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	controller := &preconditionTestController{etags: map[string]string{}}
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Object = controller
+	s.Multipart = controller
+	router := s.Router()
+
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, step.target, strings.NewReader(step.body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(step.body)))
+		for name, value := range step.headers {
+			r.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != step.code {
+			t.Fatalf("%s: expected status code %d, got %d: %s", step.name, step.code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// directiveTestController is an `ObjectController` that serves a single
+// source object with attributes, and records the attributes of copies
+type directiveTestController struct {
+	unimplementedObjectController
+	attrs *ObjectAttributes
+}
+
+func (c *directiveTestController) GetObject(r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	return &GetObjectResult{
+		ETag:    "etag",
+		Content: bytes.NewReader([]byte("content")),
+		Attributes: &ObjectAttributes{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"color": "red"},
+			Tags:        map[string]string{"env": "dev"},
+		},
+	}, nil
+}
+
+func (c *directiveTestController) PutObjectWithAttributes(r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	return nil, NotImplementedError(r)
+}
+
+func (c *directiveTestController) CopyObjectWithAttributes(r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error) {
+	c.attrs = attrs
+	return &CopyObjectResult{ETag: getResult.ETag}, nil
+}
+
+func TestCopyDirectives(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		headers     map[string]string
+		code        int
+		contentType string
+		metadata    map[string]string
+		tags        map[string]string
+	}{
+		{
+			name:        "default",
+			target:      "/bucket/dest",
+			code:        http.StatusOK,
+			contentType: "text/plain",
+			metadata:    map[string]string{"color": "red"},
+			tags:        map[string]string{"env": "dev"},
+		},
+		{
+			name:        "copy ignores request attributes",
+			target:      "/bucket/dest",
+			headers:     map[string]string{"x-amz-metadata-directive": "COPY", "x-amz-tagging-directive": "COPY", "x-amz-meta-color": "blue", "x-amz-tagging": "env=prod", "Content-Type": "text/html"},
+			code:        http.StatusOK,
+			contentType: "text/plain",
+			metadata:    map[string]string{"color": "red"},
+			tags:        map[string]string{"env": "dev"},
+		},
+		{
+			name:        "replace metadata",
+			target:      "/bucket/dest",
+			headers:     map[string]string{"x-amz-metadata-directive": "REPLACE", "x-amz-meta-size": "large", "x-amz-tagging": "env=prod", "Content-Type": "text/html"},
+			code:        http.StatusOK,
+			contentType: "text/html",
+			metadata:    map[string]string{"size": "large"},
+			tags:        map[string]string{"env": "dev"},
+		},
+		{
+			name:        "replace tags",
+			target:      "/bucket/dest",
+			headers:     map[string]string{"x-amz-tagging-directive": "REPLACE", "x-amz-meta-size": "large", "x-amz-tagging": "env=prod"},
+			code:        http.StatusOK,
+			contentType: "text/plain",
+			metadata:    map[string]string{"color": "red"},
+			tags:        map[string]string{"env": "prod"},
+		},
+		{
+			name:     "replace both",
+			target:   "/bucket/dest",
+			headers:  map[string]string{"x-amz-metadata-directive": "REPLACE", "x-amz-tagging-directive": "REPLACE"},
+			code:     http.StatusOK,
+			metadata: map[string]string{},
+			tags:     map[string]string{},
+		},
+		{
+			name:    "invalid directive",
+			target:  "/bucket/dest",
+			headers: map[string]string{"x-amz-metadata-directive": "MERGE"},
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:   "copy onto itself",
+			target: "/bucket/src",
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:     "replace onto itself",
+			target:   "/bucket/src",
+			headers:  map[string]string{"x-amz-metadata-directive": "REPLACE", "x-amz-meta-color": "blue"},
+			code:     http.StatusOK,
+			metadata: map[string]string{"color": "blue"},
+			tags:     map[string]string{"env": "dev"},
+		},
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := &directiveTestController{}
+			s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+			s.Object = controller
+
+			r := httptest.NewRequest("PUT", test.target, nil)
+			r.Header.Set("x-amz-copy-source", "/bucket/src")
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("expected status code %d, got %d: %s", test.code, rec.Code, rec.Body.String())
+			}
+			if test.code != http.StatusOK {
+				if controller.attrs != nil {
+					t.Errorf("object was copied despite the request failing")
+				}
+				return
+			}
+
+			if controller.attrs.ContentType != test.contentType {
+				t.Errorf("unexpected content type: %q", controller.attrs.ContentType)
+			}
+			if !reflect.DeepEqual(controller.attrs.Metadata, test.metadata) {
+				t.Errorf("unexpected metadata: %v", controller.attrs.Metadata)
+			}
+			if !reflect.DeepEqual(controller.attrs.Tags, test.tags) {
+				t.Errorf("unexpected tags: %v", controller.attrs.Tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package s2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// QuotaOpPutObject is the quota operation of PutObject requests
+	QuotaOpPutObject = "PutObject"
+	// QuotaOpCopyObject is the quota operation of CopyObject requests
+	QuotaOpCopyObject = "CopyObject"
+	// QuotaOpUploadPart is the quota operation of UploadPart requests
+	QuotaOpUploadPart = "UploadPart"
+	// QuotaOpUploadPartCopy is the quota operation of UploadPartCopy
+	// requests
+	QuotaOpUploadPartCopy = "UploadPartCopy"
+	// QuotaOpCompleteMultipartUpload is the quota operation of
+	// CompleteMultipartUpload requests
+	QuotaOpCompleteMultipartUpload = "CompleteMultipartUpload"
+	// QuotaOpDeleteObject is the quota operation of DeleteObject requests,
+	// and of every object deleted by DeleteObjects requests
+	QuotaOpDeleteObject = "DeleteObject"
+	// QuotaOpAbortMultipartUpload is the quota operation of
+	// AbortMultipartUpload requests
+	QuotaOpAbortMultipartUpload = "AbortMultipartUpload"
+)
+
+// QuotaController is an interface for enforcing per-bucket and per-user
+// storage quotas, e.g. on the number of bytes or objects stored. The user
+// making a request is available via `IdentityFromContext`. s2 doesn't track
+// usage itself: controllers are told about every write before it happens,
+// and about every successful write or delete afterwards, so that they can
+// keep their own accounting up-to-date.
+type QuotaController interface {
+	// CheckQuota checks whether a write would exceed any quotas, in which
+	// case it should return `QuotaExceededError`. It's called before the
+	// write's contents are passed to the object or multipart controller,
+	// and for PutObject and UploadPart, before the request body is read.
+	// `op` is one of the quota operations of writes, and `uploadID` is set
+	// for multipart upload operations. `size` is the number of bytes to be
+	// written: the declared length of the request body for PutObject and
+	// UploadPart, the length of the copied data for CopyObject and
+	// UploadPartCopy, the total size of the completed parts for
+	// CompleteMultipartUpload, and -1 if it's unknown, e.g. for uploads
+	// without a declared length, or parts whose sizes aren't listed.
+	CheckQuota(ctx context.Context, r *http.Request, op, bucket, key, uploadID string, size int64) error
+	// ReconcileQuota is called after a write or delete succeeds, so that
+	// the controller can update its accounting. `version` is the version
+	// written, or the version requested to be deleted. `size` is as in
+	// `CheckQuota`, except that for PutObject and UploadPart requests
+	// without a declared length, it's the number of bytes actually read.
+	// It's -1 for deletes and aborted uploads. Since the operation has
+	// already succeeded, errors are logged rather than returned to the
+	// client.
+	ReconcileQuota(ctx context.Context, r *http.Request, op, bucket, key, uploadID, version string, size int64) error
+}
+
+// declaredSize gets the declared length of a request's object contents,
+// which excludes the chunk metadata of streaming uploads. It returns -1 if
+// the length is unknown.
+func declaredSize(r *http.Request) int64 {
+	if decodedLengthStr := r.Header.Get("x-amz-decoded-content-length"); decodedLengthStr != "" {
+		decodedLength, err := strconv.ParseInt(decodedLengthStr, 10, 64)
+		if err != nil || decodedLength < 0 {
+			return -1
+		}
+		return decodedLength
+	}
+	return r.ContentLength
+}
+
+// completedSize gets the total size of the parts a multipart upload is
+// completed with, from the uploaded parts. It returns -1 if any part is
+// missing or has no size, since controllers aren't required to list part
+// sizes.
+func completedSize(parts []*Part, uploadedParts map[int]*Part) int64 {
+	var size int64
+	for _, part := range parts {
+		uploadedPart, ok := uploadedParts[part.PartNumber]
+		if !ok || uploadedPart.Size == 0 {
+			return -1
+		}
+		size += int64(uploadedPart.Size)
+	}
+	return size
+}
+
+// countingReader is a reader that counts the bytes read from it, so that
+// uploads without a declared length can be reconciled with their actual
+// size
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkQuota checks a write against the quota controller, if there is one
+func checkQuota(quota QuotaController, r *http.Request, op, bucket, key, uploadID string, size int64) error {
+	if quota == nil {
+		return nil
+	}
+	return quota.CheckQuota(r.Context(), r, op, bucket, key, uploadID, size)
+}
+
+// reconcileQuota notifies the quota controller, if there is one, of a
+// successful write or delete
+func reconcileQuota(logger *logrus.Entry, quota QuotaController, r *http.Request, op, bucket, key, uploadID, version string, size int64) {
+	if quota == nil {
+		return
+	}
+	if err := quota.ReconcileQuota(r.Context(), r, op, bucket, key, uploadID, version, size); err != nil {
+		logger.Errorf("could not reconcile quota after %s of %s/%s: %v", op, bucket, key, err)
+	}
+}
+
+// quotaMiddleware creates a middleware handler that checks uploads against
+// the quota controller by their declared size. It runs before
+// `bodyReadingMiddleware`, so that the bodies of uploads that would exceed
+// a quota aren't read. Other writes are checked by their handlers, e.g.
+// since the size of copies is that of their source.
+func (h *S2) quotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := operationName(r)
+		if op != QuotaOpPutObject && op != QuotaOpUploadPart {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the upload ID is taken from the URL, since parsing the form could
+		// read the body
+		vars := mux.Vars(r)
+		uploadID := r.URL.Query().Get("uploadId")
+		if err := h.Quota.CheckQuota(r.Context(), r, op, vars["bucket"], vars["key"], uploadID, declaredSize(r)); err != nil {
+			WriteError(h.logger, w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,256 @@
+package s2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// quotaTestController is a `QuotaController` that enforces a byte quota,
+// and records the writes and deletes it's told about
+type quotaTestController struct {
+	maxBytes   int64
+	usedBytes  int64
+	reconciled []string
+	checked    []int64
+	sizes      []int64
+}
+
+func (c *quotaTestController) CheckQuota(ctx context.Context, r *http.Request, op, bucket, key, uploadID string, size int64) error {
+	c.checked = append(c.checked, size)
+	if c.usedBytes+size > c.maxBytes {
+		return QuotaExceededError(r)
+	}
+	return nil
+}
+
+func (c *quotaTestController) ReconcileQuota(ctx context.Context, r *http.Request, op, bucket, key, uploadID, version string, size int64) error {
+	if size > 0 {
+		c.usedBytes += size
+	}
+	c.reconciled = append(c.reconciled, op)
+	c.sizes = append(c.sizes, size)
+	return nil
+}
+
+// quotaTestObjectController is an `ObjectController` that serves a single
+// source object, and counts the objects written to it
+type quotaTestObjectController struct {
+	copyTestController
+	puts int
+}
+
+func (c *quotaTestObjectController) PutObjectWithAttributes(r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		return nil, err
+	}
+	c.puts++
+	return &PutObjectResult{}, nil
+}
+
+func (c *quotaTestObjectController) DeleteObject(r *http.Request, bucket, key, version string) (*DeleteObjectResult, error) {
+	return &DeleteObjectResult{}, nil
+}
+
+// quotaTestMultipartController is a `MultipartController` that accepts
+// every part
+type quotaTestMultipartController struct {
+	unimplementedMultipartController
+}
+
+func (c quotaTestMultipartController) UploadMultipartChunk(r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader) (string, error) {
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func TestQuota(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	objectController := &quotaTestObjectController{}
+	quotaController := &quotaTestController{maxBytes: 10}
+	s.Object = objectController
+	s.Multipart = quotaTestMultipartController{}
+	s.Quota = quotaController
+	router := s.Router()
+
+	serve := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+
+	steps := []struct {
+		method  string
+		target  string
+		body    string
+		headers map[string]string
+		code    int
+	}{
+		{method: "PUT", target: "/bucket/a", body: "hello", code: http.StatusOK},
+		{method: "PUT", target: "/bucket/b?uploadId=upload&partNumber=1", body: "hi", code: http.StatusOK},
+		{method: "PUT", target: "/bucket/c", body: "hello", code: http.StatusForbidden},
+		{method: "PUT", target: "/bucket/c?uploadId=upload&partNumber=1", body: "hello", code: http.StatusForbidden},
+		{method: "PUT", target: "/bucket/c", headers: map[string]string{"x-amz-copy-source": "/src/obj"}, code: http.StatusForbidden},
+		{method: "DELETE", target: "/bucket/a", code: http.StatusNoContent},
+	}
+
+	for i, step := range steps {
+		rec := serve(step.method, step.target, step.body, step.headers)
+		if rec.Code != step.code {
+			t.Fatalf("unexpected status code %d for step %d: %s", rec.Code, i, rec.Body.String())
+		}
+		if step.code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "QuotaExceeded") {
+			t.Errorf("unexpected response for step %d: %s", i, rec.Body.String())
+		}
+	}
+
+	if objectController.puts != 1 {
+		t.Errorf("unexpected number of objects written: %d", objectController.puts)
+	}
+	if quotaController.usedBytes != 7 {
+		t.Errorf("unexpected bytes used: %d", quotaController.usedBytes)
+	}
+	expectedOps := []string{QuotaOpPutObject, QuotaOpUploadPart, QuotaOpDeleteObject}
+	if strings.Join(quotaController.reconciled, ",") != strings.Join(expectedOps, ",") {
+		t.Errorf("unexpected reconciled operations: %v", quotaController.reconciled)
+	}
+
+	// copies are charged the size of their source
+	quotaController.maxBytes = 14
+	if rec := serve("PUT", "/bucket/c", "", map[string]string{"x-amz-copy-source": "/src/obj"}); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+	if quotaController.usedBytes != 14 {
+		t.Errorf("unexpected bytes used: %d", quotaController.usedBytes)
+	}
+}
+
+// quotaTestCompleteController is a `MultipartController` that accepts every
+// part, and lists two uploaded parts with sizes
+type quotaTestCompleteController struct {
+	quotaTestMultipartController
+}
+
+func (c quotaTestCompleteController) ListMultipartChunks(r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error) {
+	return &ListMultipartChunksResult{
+		Parts: []*Part{
+			{PartNumber: 1, ETag: "a", Size: 3},
+			{PartNumber: 2, ETag: "b", Size: 4},
+		},
+	}, nil
+}
+
+func (c quotaTestCompleteController) CompleteMultipart(r *http.Request, bucket, key, uploadID string, parts []*Part) (*CompleteMultipartResult, error) {
+	return &CompleteMultipartResult{}, nil
+}
+
+func TestQuotaActualSizes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	quotaController := &quotaTestController{maxBytes: 100}
+	s.Object = &quotaTestObjectController{}
+	s.Multipart = quotaTestCompleteController{}
+	s.Quota = quotaController
+	router := s.Router()
+
+	// uploads without a declared length are reconciled with the number of
+	// bytes read
+	for _, target := range []string{"/bucket/key", "/bucket/key?uploadId=upload&partNumber=1"} {
+		r := httptest.NewRequest("PUT", target, strings.NewReader("hello"))
+		r.ContentLength = -1
+		r.Header.Set("Transfer-Encoding", "chunked")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status code %d for %s: %s", rec.Code, target, rec.Body.String())
+		}
+	}
+
+	// completed uploads are charged the total size of their parts
+	body := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>a</ETag></Part><Part><PartNumber>2</PartNumber><ETag>b</ETag></Part></CompleteMultipartUpload>"
+	r := httptest.NewRequest("POST", "/bucket/key?uploadId=upload", strings.NewReader(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if fmt.Sprint(quotaController.checked) != "[-1 -1 7]" {
+		t.Errorf("unexpected checked sizes: %v", quotaController.checked)
+	}
+	if fmt.Sprint(quotaController.sizes) != "[5 5 7]" {
+		t.Errorf("unexpected reconciled sizes: %v", quotaController.sizes)
+	}
+}
+
+func TestDeclaredSize(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/bucket/key", bytes.NewReader([]byte("hello")))
+	if size := declaredSize(r); size != 5 {
+		t.Errorf("unexpected size: %d", size)
+	}
+	r.Header.Set("x-amz-decoded-content-length", "3")
+	if size := declaredSize(r); size != 3 {
+		t.Errorf("unexpected size: %d", size)
+	}
+	r.Header.Set("x-amz-decoded-content-length", "invalid")
+	if size := declaredSize(r); size != -1 {
+		t.Errorf("unexpected size: %d", size)
+	}
+}
+
+// quotaTestBody is a request body that records whether it was read
+type quotaTestBody struct {
+	io.Reader
+	read bool
+}
+
+func (b *quotaTestBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func TestQuotaBodyNotRead(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.Object = &quotaTestObjectController{}
+	s.Multipart = quotaTestMultipartController{}
+	s.Quota = &quotaTestController{maxBytes: 4}
+	router := s.Router()
+
+	for _, target := range []string{"/bucket/key", "/bucket/key?uploadId=upload&partNumber=1"} {
+		body := &quotaTestBody{Reader: strings.NewReader("hello")}
+		r := httptest.NewRequest("PUT", target, body)
+		r.Header.Set("Content-Length", "5")
+		r.ContentLength = 5
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "QuotaExceeded") {
+			t.Errorf("unexpected response %d for %s: %s", rec.Code, target, rec.Body.String())
+		}
+		if body.read {
+			t.Errorf("unexpected read of the body of %s", target)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package s2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayCache is an interface for stores of the signatures of recently
+// authenticated requests, which are used to reject replays of captured
+// mutating requests. Clustered deployments can share a store between s2
+// instances, e.g. by backing it with a distributed cache.
+type ReplayCache interface {
+	// Seen records a request signature, and returns whether it was already
+	// recorded. The signature needs to be remembered until `expiry`; after
+	// that, replays are rejected by the clock skew check instead.
+	Seen(ctx context.Context, signature string, expiry time.Time) (bool, error)
+}
+
+// MemoryReplayCache is an in-memory `ReplayCache`, for deployments with a
+// single s2 instance. It's safe for concurrent use.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	expiry  map[string]time.Time
+	nextGC  time.Time
+	gcEvery time.Duration
+}
+
+// NewMemoryReplayCache creates a new in-memory replay cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		expiry:  map[string]time.Time{},
+		gcEvery: time.Minute,
+	}
+}
+
+// Seen records a request signature, and returns whether it was already
+// recorded
+func (c *MemoryReplayCache) Seen(ctx context.Context, signature string, expiry time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.nextGC) {
+		for s, e := range c.expiry {
+			if now.After(e) {
+				delete(c.expiry, s)
+			}
+		}
+		c.nextGC = now.Add(c.gcEvery)
+	}
+
+	if e, ok := c.expiry[signature]; ok && !now.After(e) {
+		return true, nil
+	}
+	c.expiry[signature] = expiry
+	return false, nil
+}
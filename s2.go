@@ -2,6 +2,7 @@ package s2
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
@@ -17,6 +18,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -26,14 +28,18 @@ var (
 	// header when using AWs' auth V2
 	authV2HeaderValidator = regexp.MustCompile(`^AWS ([^:]*):(.*)$`)
 	// authV4HeaderValidator is a regex for validating the authorization
-	// header when using AWs' auth V4
-	authV4HeaderValidator = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]*)/([^/]*)/([^/]*)/s3/aws4_request, ?SignedHeaders=([^,]+), ?Signature=(.+)$`)
-
-	// subresourceQueryParams is a list of query parameters that are
-	// considered queries for "subresources" in S3. This is used in
-	// auth validation.
+	// header when using AWs' auth V4. Requests to the STS endpoint are
+	// signed for the sts service rather than s3, which `authV4` rejects on
+	// every other route.
+	authV4HeaderValidator = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]*)/([^/]*)/([^/]*)/(s3|sts)/aws4_request, ?SignedHeaders=([^,]+), ?Signature=(.+)$`)
+
+	// subresourceQueryParams is a sorted list of query parameters that are
+	// considered queries for "subresources" in S3, including the response
+	// header overrides. This is used in auth V2 validation.
 	subresourceQueryParams = []string{
 		"acl",
+		"cors",
+		"delete",
 		"lifecycle",
 		"location",
 		"logging",
@@ -41,12 +47,21 @@ var (
 		"partNumber",
 		"policy",
 		"requestPayment",
+		"response-cache-control",
+		"response-content-disposition",
+		"response-content-encoding",
+		"response-content-language",
+		"response-content-type",
+		"response-expires",
+		"restore",
+		"tagging",
 		"torrent",
 		"uploadId",
 		"uploads",
 		"versionId",
 		"versioning",
 		"versions",
+		"website",
 	}
 )
 
@@ -59,7 +74,8 @@ func NotImplementedEndpoint(logger *logrus.Entry) http.HandlerFunc {
 	}
 }
 
-// attachBucketRoutes adds bucket-related routes to a router
+// attachBucketRoutes adds bucket-related routes to a router. Implemented
+// routes are named after their S3 operation, which labels their metrics.
 func attachBucketRoutes(logger *logrus.Entry, router *mux.Router, handler *bucketHandler, multipartHandler *multipartHandler, objectHandler *objectHandler) {
 	router.Methods("GET", "PUT").Queries("accelerate", "").HandlerFunc(NotImplementedEndpoint(logger))
 	router.Methods("GET", "PUT").Queries("acl", "").HandlerFunc(NotImplementedEndpoint(logger))
@@ -80,21 +96,23 @@ func attachBucketRoutes(logger *logrus.Entry, router *mux.Router, handler *bucke
 	router.Methods("GET", "PUT", "DELETE").Queries("tagging", "").HandlerFunc(NotImplementedEndpoint(logger))
 	router.Methods("GET", "PUT", "DELETE").Queries("website", "").HandlerFunc(NotImplementedEndpoint(logger))
 
-	router.Methods("GET").Queries("versioning", "").HandlerFunc(handler.versioning)
-	router.Methods("PUT").Queries("versioning", "").HandlerFunc(handler.setVersioning)
-	router.Methods("GET").Queries("versions", "").HandlerFunc(handler.listVersions)
-	router.Methods("GET").Queries("uploads", "").HandlerFunc(multipartHandler.list)
-	router.Methods("GET").Queries("location", "").HandlerFunc(handler.location)
-	router.Methods("GET", "HEAD").HandlerFunc(handler.get)
-	router.Methods("PUT").HandlerFunc(handler.put)
-	router.Methods("POST").Queries("delete", "").HandlerFunc(objectHandler.post)
-	router.Methods("DELETE").HandlerFunc(handler.del)
+	router.Methods("GET").Queries("versioning", "").HandlerFunc(handler.versioning).Name("GetBucketVersioning")
+	router.Methods("PUT").Queries("versioning", "").HandlerFunc(handler.setVersioning).Name("PutBucketVersioning")
+	router.Methods("GET").Queries("versions", "").HandlerFunc(handler.listVersions).Name("ListObjectVersions")
+	router.Methods("GET").Queries("uploads", "").HandlerFunc(multipartHandler.list).Name("ListMultipartUploads")
+	router.Methods("GET").Queries("location", "").HandlerFunc(handler.location).Name("GetBucketLocation")
+	router.Methods("GET").HandlerFunc(handler.get).Name("ListObjects")
+	router.Methods("HEAD").HandlerFunc(handler.head).Name("HeadBucket")
+	router.Methods("PUT").HandlerFunc(handler.put).Name("CreateBucket")
+	router.Methods("POST").Queries("delete", "").HandlerFunc(objectHandler.post).Name("DeleteObjects")
+	router.Methods("DELETE").HandlerFunc(handler.del).Name("DeleteBucket")
 
 	// catch-all for POST calls that aren't using the delete subresource
 	router.Methods("POST").HandlerFunc(NotImplementedEndpoint(logger))
 }
 
-// attachBucketRoutes adds object-related routes to a router
+// attachBucketRoutes adds object-related routes to a router. As with bucket
+// routes, implemented routes are named after their S3 operation.
 func attachObjectRoutes(logger *logrus.Entry, router *mux.Router, handler *objectHandler, multipartHandler *multipartHandler) {
 	router.Methods("GET", "PUT").Queries("acl", "").HandlerFunc(NotImplementedEndpoint(logger))
 	router.Methods("GET", "PUT").Queries("legal-hold", "").HandlerFunc(NotImplementedEndpoint(logger))
@@ -104,15 +122,17 @@ func attachObjectRoutes(logger *logrus.Entry, router *mux.Router, handler *objec
 	router.Methods("POST").Queries("restore", "").HandlerFunc(NotImplementedEndpoint(logger))
 	router.Methods("POST").Queries("select", "").HandlerFunc(NotImplementedEndpoint(logger))
 
-	router.Methods("GET").Queries("uploadId", "").HandlerFunc(multipartHandler.listChunks)
-	router.Methods("POST").Queries("uploads", "").HandlerFunc(multipartHandler.init)
-	router.Methods("POST").Queries("uploadId", "").HandlerFunc(multipartHandler.complete)
-	router.Methods("PUT").Queries("uploadId", "").HandlerFunc(multipartHandler.put)
-	router.Methods("DELETE").Queries("uploadId", "").HandlerFunc(multipartHandler.del)
-	router.Methods("GET", "HEAD").HandlerFunc(handler.get)
-	router.Methods("PUT").Headers("x-amz-copy-source", "").HandlerFunc(handler.copy)
-	router.Methods("PUT").HandlerFunc(handler.put)
-	router.Methods("DELETE").HandlerFunc(handler.del)
+	router.Methods("GET").Queries("uploadId", "").HandlerFunc(multipartHandler.listChunks).Name("ListParts")
+	router.Methods("POST").Queries("uploads", "").HandlerFunc(multipartHandler.init).Name("CreateMultipartUpload")
+	router.Methods("POST").Queries("uploadId", "").HandlerFunc(multipartHandler.complete).Name("CompleteMultipartUpload")
+	router.Methods("PUT").Queries("uploadId", "").Headers("x-amz-copy-source", "").HandlerFunc(multipartHandler.copy).Name("UploadPartCopy")
+	router.Methods("PUT").Queries("uploadId", "").HandlerFunc(multipartHandler.put).Name("UploadPart")
+	router.Methods("DELETE").Queries("uploadId", "").HandlerFunc(multipartHandler.del).Name("AbortMultipartUpload")
+	router.Methods("GET").HandlerFunc(handler.get).Name("GetObject")
+	router.Methods("HEAD").HandlerFunc(handler.head).Name("HeadObject")
+	router.Methods("PUT").Headers("x-amz-copy-source", "").HandlerFunc(handler.copy).Name("CopyObject")
+	router.Methods("PUT").HandlerFunc(handler.put).Name("PutObject")
+	router.Methods("DELETE").HandlerFunc(handler.del).Name("DeleteObject")
 }
 
 // S2 is the root struct used in the s2 library
@@ -125,6 +145,81 @@ type S2 struct {
 	logger               *logrus.Entry
 	maxRequestBodyLength uint32
 	readBodyTimeout      time.Duration
+
+	// ValidateMultipartParts specifies whether s2 should validate the parts
+	// list of CompleteMultipartUpload requests against the parts returned
+	// by `ListMultipartChunks`, before calling `CompleteMultipart`. This
+	// checks for duplicate parts, missing parts, mismatched ETags, and
+	// parts (other than the last) that are smaller than
+	// `MinMultipartPartSize`.
+	ValidateMultipartParts bool
+	// MinMultipartPartSize specifies the minimum size of every part but the
+	// last in a multipart upload, when `ValidateMultipartParts` is enabled.
+	// This defaults to 5 MiB, as in S3, but can be lowered for testing.
+	MinMultipartPartSize uint64
+	// ClockSkew specifies the maximum delta between the current time and
+	// the time a request was signed at, for auth V4 and V2. This defaults to
+	// 15 minutes, as in S3, which is also used if it's 0.
+	ClockSkew time.Duration
+	// ReplayCache specifies an optional store of the signatures of recently
+	// authenticated requests. If set, mutating requests (PUT, POST and
+	// DELETE) made using auth V4 or V2 are rejected if their signature has
+	// already been seen within `ClockSkew`. Signatures are only recorded
+	// once a request passes s2's own checks (e.g. throttling and digests),
+	// so requests rejected by those can be retried as-is; clients need to
+	// sign retries of other requests anew for them to be accepted.
+	ReplayCache ReplayCache
+
+	// AuthContext, ServiceContext, BucketContext, ObjectContext and
+	// MultipartContext are context-aware variants of the controllers above.
+	// If set, they're used instead of their counterparts. Their methods are
+	// passed the request's context, which is cancelled if the client
+	// disconnects, and holds the request ID and authenticated access key
+	// (see `RequestIDFromContext` and `AccessKeyFromContext`.) Controllers
+	// that aren't context-aware receive the same context via
+	// `r.Context()`.
+	AuthContext      AuthContextController
+	ServiceContext   ServiceContextController
+	BucketContext    BucketContextController
+	ObjectContext    ObjectContextController
+	MultipartContext MultipartContextController
+
+	// SigningKeyCache specifies an optional cache of auth V4 signing keys.
+	// If set, the auth controller isn't consulted for requests whose
+	// signing key is cached; see `SigningKeyCache` for details.
+	SigningKeyCache *SigningKeyCache
+
+	// Limits specifies an optional source of per-access key and per-bucket
+	// throttling limits. If set, requests that exceed them are rejected
+	// with `SlowDownError`.
+	Limits LimitsController
+
+	// Quota specifies an optional controller for enforcing storage quotas.
+	// If set, it's consulted before object and multipart writes, and told
+	// about successful writes and deletes.
+	Quota QuotaController
+
+	// Metrics specifies optional Prometheus metrics, which are recorded
+	// for every request; see `NewMetrics`.
+	Metrics *Metrics
+	// ServeMetrics specifies whether `Metrics` should be served at
+	// `GET /metrics`. The endpoint isn't authenticated, and shadows
+	// listing a bucket named `metrics` without a trailing slash. Metrics
+	// can instead be served elsewhere via `Metrics.Handler`.
+	ServeMetrics bool
+
+	// TracerProvider specifies an optional OpenTelemetry tracer provider.
+	// If set, every request is traced as a span named after its S3
+	// operation, continuing the request's W3C trace context if it has one.
+	// Auth, body reading and every controller call are traced as child
+	// spans, and S3 error codes and request IDs are recorded as span
+	// attributes.
+	TracerProvider trace.TracerProvider
+
+	// STS specifies an optional issuer of temporary credentials. If set, a
+	// minimal STS-compatible endpoint is served at `POST /`, which supports
+	// the AssumeRole action.
+	STS CredentialsIssuer
 }
 
 // NewS2 creates a new S2 instance. One created, you set zero or more
@@ -139,6 +234,8 @@ func NewS2(logger *logrus.Entry, maxRequestBodyLength uint32, readBodyTimeout ti
 		Bucket:               unimplementedBucketController{},
 		Object:               unimplementedObjectController{},
 		Multipart:            unimplementedMultipartController{},
+		MinMultipartPartSize: defaultMinPartSize,
+		ClockSkew:            defaultClockSkew,
 		logger:               logger,
 		maxRequestBodyLength: maxRequestBodyLength,
 		readBodyTimeout:      readBodyTimeout,
@@ -158,184 +255,220 @@ func (h *S2) requestIDMiddleware(next http.Handler) http.Handler {
 		}
 
 		vars["requestID"] = id.String()
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withContextValue(r, requestIDContextKey, id.String()))
 	})
 }
 
-// authV4 validates a request using AWS' auth V4
-func (h *S2) authV4(w http.ResponseWriter, r *http.Request, auth string) error {
+// secretKey gets the expected secret key of an access key from the auth
+// controller, along with the identity it belongs to, passing along the
+// request's session token if there is one
+func (h *S2) secretKey(r *http.Request, accessKey string, region *string) (*string, *Identity, error) {
+	var secretKey *string
+	var identity *Identity
+	var err error
+
+	token := sessionToken(r)
+	sessionController := h.sessionAuthController()
+	if sessionController == nil {
+		// session tokens are ignored if the auth controller doesn't support
+		// temporary credentials
+		token = ""
+	}
+
+	if token != "" {
+		secretKey, identity, err = sessionController.SessionSecretKey(r.Context(), r, accessKey, token, region)
+		if err != nil {
+			return nil, nil, newGenericError(r, err)
+		}
+	} else {
+		secretKey, identity, err = h.identityAuthController().SecretKeyIdentity(r.Context(), r, accessKey, region)
+		if err != nil {
+			return nil, nil, InternalError(r, err)
+		}
+	}
+	if secretKey == nil {
+		return nil, nil, InvalidAccessKeyIDError(r)
+	}
+
+	// the identity is copied before its auth fields are filled in, since the
+	// controller may return the same identity for concurrent requests
+	copied := Identity{}
+	if identity != nil {
+		copied = *identity
+	}
+	copied.SessionToken = token
+	return secretKey, &copied, nil
+}
+
+// signingKey gets the auth V4 signing key of an access key, along with the
+// identity it belongs to. The key is derived from the expected secret key,
+// unless it's in the signing key cache.
+func (h *S2) signingKey(r *http.Request, accessKey, date, region, service string) ([]byte, *Identity, error) {
+	cache := h.SigningKeyCache
+	if sessionToken(r) != "" {
+		// session tokens need to be validated on every request
+		cache = nil
+	}
+
+	cacheKey := signingKeyCacheKey{
+		accessKey: accessKey,
+		date:      date,
+		region:    region,
+		service:   service,
+	}
+	if cache != nil {
+		if signingKey, identity, ok := cache.get(cacheKey); ok {
+			return signingKey, identity, nil
+		}
+	}
+
+	secretKey, identity, err := h.secretKey(r, accessKey, &region)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signingKey := signingKeyV4(*secretKey, date, region, service)
+
+	if cache != nil {
+		cache.add(cacheKey, signingKey, identity)
+	}
+	return signingKey, identity, nil
+}
+
+// replayCheck is the signature of a mutating request, which is checked
+// against the replay cache once the request has passed validation
+type replayCheck struct {
+	signature string
+	expiry    time.Time
+}
+
+// clockSkew returns the maximum delta allowed between the current time and
+// the time a request was signed at
+func (h *S2) clockSkew() time.Duration {
+	if h.ClockSkew == 0 {
+		return defaultClockSkew
+	}
+	return h.ClockSkew
+}
+
+// newReplayCheck creates a replay check of a request's signature, or
+// returns nil if no replay cache is configured or the request isn't mutating
+func (h *S2) newReplayCheck(r *http.Request, signature string, timestamp time.Time) *replayCheck {
+	if h.ReplayCache == nil {
+		return nil
+	}
+	if r.Method != "PUT" && r.Method != "POST" && r.Method != "DELETE" {
+		return nil
+	}
+	return &replayCheck{
+		signature: signature,
+		expiry:    timestamp.Add(h.clockSkew()),
+	}
+}
+
+// authV4 validates a request using AWS' auth V4. It returns the identity
+// the request was authenticated as, along with its signing data, which may
+// be reused for verifying chunked uploads.
+func (h *S2) authV4(w http.ResponseWriter, r *http.Request, auth string) (*Identity, *chunkSigner, *replayCheck, error) {
 	// parse auth-related headers
 	match := authV4HeaderValidator.FindStringSubmatch(auth)
 	if len(match) == 0 {
-		return AuthorizationHeaderMalformedError(r)
+		return nil, nil, nil, AuthorizationHeaderMalformedError(r)
 	}
 
 	accessKey := match[1]
 	date := match[2]
 	region := match[3]
-	signedHeaderKeys := strings.Split(match[4], ";")
+	service := match[4]
+	signedHeaderKeys := strings.Split(match[5], ";")
 	sort.Strings(signedHeaderKeys)
-	expectedSignature := match[5]
+	expectedSignature := match[6]
+	if service == "sts" && operationName(r) != assumeRoleOperation {
+		return nil, nil, nil, AuthorizationHeaderMalformedError(r)
+	}
 
-	// get the expected secret key
-	secretKey, err := h.Auth.SecretKey(r, accessKey, &region)
+	// get the signing key, which is derived from the expected secret key
+	signingKey, identity, err := h.signingKey(r, accessKey, date, region, service)
 	if err != nil {
-		return InternalError(r, err)
-	}
-	if secretKey == nil {
-		return InvalidAccessKeyIDError(r)
+		return nil, nil, nil, err
 	}
 
 	// step 1: construct the canonical request
-	var signedHeaders strings.Builder
-	for _, key := range signedHeaderKeys {
-		signedHeaders.WriteString(key)
-		signedHeaders.WriteString(":")
-		if key == "host" {
-			signedHeaders.WriteString(r.Host)
-		} else {
-			signedHeaders.WriteString(strings.TrimSpace(r.Header.Get(key)))
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" && service == "sts" {
+		// unlike S3, STS doesn't require `x-amz-content-sha256`, so the
+		// payload hash may need to be computed from the body
+		body, err := h.bufferBody(r, maxSTSRequestBodyLength)
+		if err != nil {
+			return nil, nil, nil, err
 		}
-		signedHeaders.WriteString("\n")
+		payloadHash = fmt.Sprintf("%x", sha256.Sum256(body))
 	}
 
-	canonicalRequest := strings.Join([]string{
-		r.Method,
-		normURI(r.URL.Path),
-		normQuery(r.URL.Query()),
-		signedHeaders.String(),
-		strings.Join(signedHeaderKeys, ";"),
-		r.Header.Get("x-amz-content-sha256"),
-	}, "\n")
+	canonicalRequest := canonicalRequestV4(r, signedHeaderKeys, payloadHash)
 
-	timestamp, err := parseAWSTimestamp(r)
+	timestamp, err := parseAWSTimestamp(r, h.clockSkew())
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	formattedTimestamp := formatAWSTimestamp(timestamp)
 
 	// step 2: construct the string to sign
-	stringToSign := fmt.Sprintf(
-		"AWS4-HMAC-SHA256\n%s\n%s/%s/s3/aws4_request\n%x",
-		formattedTimestamp,
-		date,
-		region,
-		sha256.Sum256([]byte(canonicalRequest)),
-	)
-
-	// step 3: calculate the signing key
-	dateKey := hmacSHA256([]byte("AWS4"+*secretKey), date)
-	dateRegionKey := hmacSHA256(dateKey, region)
-	dateRegionServiceKey := hmacSHA256(dateRegionKey, "s3")
-	signingKey := hmacSHA256(dateRegionServiceKey, "aws4_request")
-
-	// step 4: construct & verify the signature
+	stringToSign := stringToSignV4(canonicalRequest, formattedTimestamp, date, region, service)
+
+	// step 3: construct & verify the signature
 	signature := hmacSHA256(signingKey, stringToSign)
 
 	if expectedSignature != fmt.Sprintf("%x", signature) {
-		return SignatureDoesNotMatchError(r)
-	}
-
-	vars := mux.Vars(r)
-	vars["authMethod"] = "v4"
-	vars["authAccessKey"] = accessKey
-	vars["authRegion"] = region
-	// store signature data as vars, since it may be reused for verifying chunked uploads
-	vars["authSignature"] = expectedSignature
-	// This is a bit unfortunate -- `vars` can only store string values, so we need to
-	// convert the bytes to a string. Note that this string may not be valid,
-	// i.e. it may contain non-utf8 sequences.
-	vars["authSignatureKey"] = string(signingKey)
-	vars["authSignatureTimestamp"] = formattedTimestamp
-	vars["authSignatureDate"] = date
-	vars["authSignatureRegion"] = region
-	return nil
+		return nil, nil, nil, SignatureDoesNotMatchError(r)
+	}
+
+	identity.AccessKey = accessKey
+	identity.Method = AuthMethodV4
+	identity.Region = region
+
+	signer := &chunkSigner{
+		signingKey:    signingKey,
+		seedSignature: expectedSignature,
+		timestamp:     formattedTimestamp,
+		date:          date,
+		region:        region,
+	}
+	return identity, signer, h.newReplayCheck(r, expectedSignature, timestamp), nil
 }
 
-// authV2 validates a request using AWS' auth V2
-func (h *S2) authV2(w http.ResponseWriter, r *http.Request, auth string) error {
+// authV2 validates a request using AWS' auth V2. It returns the identity
+// the request was authenticated as.
+func (h *S2) authV2(w http.ResponseWriter, r *http.Request, auth string) (*Identity, *replayCheck, error) {
 	// parse auth-related headers
 	match := authV2HeaderValidator.FindStringSubmatch(auth)
 	if len(match) == 0 {
-		return InvalidArgumentError(r)
+		return nil, nil, InvalidArgumentError(r)
 	}
 
 	accessKey := match[1]
 	expectedSignature := match[2]
 
 	// get the expected secret key
-	secretKey, err := h.Auth.SecretKey(r, accessKey, nil)
+	secretKey, identity, err := h.secretKey(r, accessKey, nil)
 	if err != nil {
-		return InternalError(r, err)
-	}
-	if secretKey == nil {
-		return InvalidAccessKeyIDError(r)
+		return nil, nil, err
 	}
 
-	timestamp, err := parseAWSTimestamp(r)
+	timestamp, err := parseAWSTimestamp(r, h.clockSkew())
 	if err != nil {
-		return err
-	}
-
-	amzHeaderKeys := []string{}
-	for key := range r.Header {
-		if strings.HasPrefix(key, "x-amz-") {
-			amzHeaderKeys = append(amzHeaderKeys, key)
-		}
-	}
-	sort.Strings(amzHeaderKeys)
-
-	stringToSignParts := []string{
-		r.Method,
-		r.Header.Get("content-md5"),
-		r.Header.Get("content-type"),
-		timestamp.Format(time.RFC1123),
+		return nil, nil, err
 	}
 
-	for _, key := range amzHeaderKeys {
-		// NOTE: this doesn't properly handle multiple header values, or
-		// header values with repeated whitespace characters
-		value := fmt.Sprintf("%s:%s", key, strings.TrimSpace(r.Header.Get(key)))
-		stringToSignParts = append(stringToSignParts, value)
-	}
-
-	var canonicalizedResource strings.Builder
-	canonicalizedResource.WriteString(r.URL.Path)
-	query := r.URL.Query()
-	appendedQuery := false
-	for _, k := range subresourceQueryParams {
-		_, ok := query[k]
-		if ok {
-			if appendedQuery {
-				canonicalizedResource.WriteString("&")
-			} else {
-				canonicalizedResource.WriteString("?")
-				appendedQuery = true
-			}
-
-			canonicalizedResource.WriteString(k)
-
-			value := query.Get(k)
-			if value != "" {
-				// NOTE: this doesn't properly handle multiple query params
-				canonicalizedResource.WriteString("=")
-				canonicalizedResource.WriteString(value)
-			}
+	for _, stringToSign := range stringsToSignV2(r) {
+		signature := base64.StdEncoding.EncodeToString(hmacSHA1([]byte(*secretKey), stringToSign))
+		if expectedSignature == signature {
+			identity.AccessKey = accessKey
+			identity.Method = AuthMethodV2
+			return identity, h.newReplayCheck(r, expectedSignature, timestamp), nil
 		}
 	}
-	stringToSignParts = append(stringToSignParts, canonicalizedResource.String())
-
-	stringToSign := strings.Join(stringToSignParts, "\n")
-	signature := base64.StdEncoding.EncodeToString(hmacSHA1([]byte(*secretKey), stringToSign))
-
-	if expectedSignature != signature {
-		return AccessDeniedError(r)
-	}
-
-	vars := mux.Vars(r)
-	vars["authMethod"] = "v2"
-	vars["authAccessKey"] = accessKey
-	return nil
+	return nil, nil, AccessDeniedError(r)
 }
 
 // authMiddleware creates a middleware handler for dealing with AWS auth
@@ -345,28 +478,78 @@ func (h *S2) authMiddleware(next http.Handler) http.Handler {
 	// client-side:
 	// https://github.com/smartystreets/go-aws-auth
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if operationName(r) == metricsOperation {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		auth := r.Header.Get("authorization")
 
-		passed := true
+		var identity *Identity
+		var signer *chunkSigner
+		var replay *replayCheck
 		var err error
 		if strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
-			err = h.authV4(w, r, auth)
+			identity, signer, replay, err = h.authV4(w, r, auth)
 		} else if strings.HasPrefix(auth, "AWS ") {
-			err = h.authV2(w, r, auth)
+			identity, replay, err = h.authV2(w, r, auth)
 		} else {
-			passed, err = h.Auth.CustomAuth(r)
-			vars := mux.Vars(r)
-			vars["authMethod"] = "custom"
+			identity, err = h.identityAuthController().CustomAuthIdentity(r.Context(), r)
+			if err == nil && identity == nil {
+				err = AccessDeniedError(r)
+			} else if err == nil {
+				// copied for the same reason as in `secretKey`
+				copied := *identity
+				identity = &copied
+			}
 		}
 		if err != nil {
 			WriteError(h.logger, w, r, err)
 			return
 		}
-		if !passed {
-			WriteError(h.logger, w, r, AccessDeniedError(r))
-			return
+		if identity.Method == "" {
+			identity.Method = AuthMethodCustom
 		}
 
+		// these vars are kept for backwards compatibility; prefer
+		// `IdentityFromRequest`
+		vars := mux.Vars(r)
+		vars["authMethod"] = identity.Method
+		if identity.AccessKey != "" {
+			vars["authAccessKey"] = identity.AccessKey
+		}
+		if identity.Region != "" {
+			vars["authRegion"] = identity.Region
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey, identity)
+		if signer != nil {
+			ctx = context.WithValue(ctx, chunkSignerContextKey, signer)
+		}
+		if replay != nil {
+			ctx = context.WithValue(ctx, replayCheckContextKey, replay)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// replayMiddleware creates a middleware handler that rejects replays of
+// mutating requests. It runs after the other middleware, so that the
+// signatures of requests they reject (e.g. with `SlowDownError`) aren't
+// recorded, and the requests can be retried.
+func (h *S2) replayMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if replay, ok := r.Context().Value(replayCheckContextKey).(*replayCheck); ok {
+			seen, err := h.ReplayCache.Seen(r.Context(), replay.signature, replay.expiry)
+			if err != nil {
+				WriteError(h.logger, w, r, InternalError(r, err))
+				return
+			}
+			if seen {
+				WriteError(h.logger, w, r, AccessDeniedError(r))
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -408,8 +591,9 @@ func (h *S2) bodyReadingMiddleware(next http.Handler) http.Handler {
 			r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 		}
 
-		expectedSHA256, ok := singleHeader(r, "x-amz-content-sha256")
-		if ok {
+		// unsigned and streaming payloads don't have a hash of the body
+		expectedSHA256 := r.Header.Get("x-amz-content-sha256")
+		if expectedSHA256 != "" && expectedSHA256 != unsignedPayload && !strings.HasPrefix(expectedSHA256, "STREAMING-") {
 			if len(expectedSHA256) != 64 {
 				WriteError(h.logger, w, r, InvalidDigestError(r))
 				return
@@ -435,26 +619,42 @@ func (h *S2) bodyReadingMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
+		// additional checksums only apply to uploaded object contents, and
+		// streaming payloads are validated as they're decoded
+		if r.Method == "PUT" && r.Header.Get("x-amz-copy-source") == "" && !strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") {
+			checksum, err := checksumFromRequest(r)
+			if err != nil {
+				WriteError(h.logger, w, r, err)
+				return
+			}
+			if checksum != nil && computeChecksum(checksum.Algorithm, body) != checksum.Value {
+				WriteError(h.logger, w, r, BadDigestError(r))
+				return
+			}
+			r = withContextValue(r, bodyVerifiedContextKey, true)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// readBody efficiently reads a request body, or times out
+// readBody efficiently reads a request body, or times out. It also gives up
+// if the request is cancelled.
 func (h *S2) readBody(r *http.Request, length uint32) (*bytes.Buffer, error) {
 	var body bytes.Buffer
 	body.Grow(int(length))
 
-	ch := make(chan error)
+	ch := make(chan error, 1)
 	go func() {
 		n, err := body.ReadFrom(r.Body)
 		r.Body.Close()
 		if err != nil {
 			ch <- err
-		}
-		if uint32(n) != length {
+		} else if uint32(n) != length {
 			ch <- IncompleteBodyError(r)
+		} else {
+			ch <- nil
 		}
-		ch <- nil
 	}()
 
 	select {
@@ -464,37 +664,131 @@ func (h *S2) readBody(r *http.Request, length uint32) (*bytes.Buffer, error) {
 		}
 		return &body, nil
 	case <-time.After(h.readBodyTimeout):
-		return nil, nil
+	case <-r.Context().Done():
+	}
+
+	// closing the body unblocks the pending read, so that the goroutine
+	// doesn't outlive the request or keep writing to the buffer
+	r.Body.Close()
+	<-ch
+	return nil, nil
+}
+
+// bufferBody reads the whole body of a request, replacing it with a buffered
+// copy so that it can be read again. Bodies longer than `maxLength` are
+// rejected.
+func (h *S2) bufferBody(r *http.Request, maxLength int64) ([]byte, error) {
+	if r.ContentLength < 0 {
+		return nil, MissingContentLengthError(r)
 	}
+	if r.ContentLength > maxLength {
+		return nil, EntityTooLargeError(r)
+	}
+
+	body, err := h.readBody(r, uint32(r.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, RequestTimeoutError(r)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body.Bytes()))
+	return body.Bytes(), nil
 }
 
 // Router creates a new mux router.
 func (h *S2) Router() *mux.Router {
+	serviceController := h.serviceContextController()
+	bucketController, headBucketController := h.bucketContextControllers()
+	objectController, objectPartsController, headObjectController := h.objectContextControllers()
+	multipartController := h.multipartContextController()
+
+	var tracer trace.Tracer
+	if h.TracerProvider != nil {
+		tracer = h.TracerProvider.Tracer(tracerName)
+
+		serviceController = tracedServiceController{controller: serviceController, tracer: tracer}
+		bucketController = tracedBucketController{controller: bucketController, tracer: tracer}
+		if headBucketController != nil {
+			headBucketController = tracedHeadBucketController{controller: headBucketController, tracer: tracer}
+		}
+		objectController = tracedObjectController{controller: objectController, tracer: tracer}
+		if objectPartsController != nil {
+			objectPartsController = tracedObjectPartsController{controller: objectPartsController, tracer: tracer}
+		}
+		if headObjectController != nil {
+			headObjectController = tracedHeadObjectController{controller: headObjectController, tracer: tracer}
+		}
+		multipartController = tracedMultipartController{controller: multipartController, tracer: tracer}
+	}
+
 	serviceHandler := &serviceHandler{
-		controller: h.Service,
+		controller: serviceController,
 		logger:     h.logger,
 	}
 	bucketHandler := &bucketHandler{
-		controller: h.Bucket,
-		logger:     h.logger,
+		controller:     bucketController,
+		headController: headBucketController,
+		logger:         h.logger,
 	}
 	objectHandler := &objectHandler{
-		controller: h.Object,
-		logger:     h.logger,
+		controller:      objectController,
+		partsController: objectPartsController,
+		headController:  headObjectController,
+		quota:           h.Quota,
+		logger:          h.logger,
 	}
 	multipartHandler := &multipartHandler{
-		controller: h.Multipart,
-		logger:     h.logger,
+		controller:           multipartController,
+		objectController:     objectController,
+		headObjectController: headObjectController,
+		quota:                h.Quota,
+		logger:               h.logger,
+		validateParts:        h.ValidateMultipartParts,
+		minPartSize:          h.MinMultipartPartSize,
 	}
 
 	router := mux.NewRouter()
+	if h.Metrics != nil {
+		router.Use(h.metricsMiddleware)
+	}
 	router.Use(h.requestIDMiddleware)
-	if h.Auth != nil {
-		router.Use(h.authMiddleware)
+	if tracer != nil {
+		router.Use(h.tracingMiddleware(tracer))
+	}
+	if h.authContextController() != nil {
+		if tracer != nil {
+			router.Use(traceMiddleware(tracer, "authMiddleware", h.authMiddleware))
+		} else {
+			router.Use(h.authMiddleware)
+		}
+	}
+	if h.Limits != nil {
+		router.Use(h.limitsMiddleware(newLimiter()))
+	}
+	if h.Quota != nil {
+		router.Use(h.quotaMiddleware)
+	}
+	if tracer != nil {
+		router.Use(traceMiddleware(tracer, "bodyReadingMiddleware", h.bodyReadingMiddleware))
+	} else {
+		router.Use(h.bodyReadingMiddleware)
+	}
+	if h.ReplayCache != nil {
+		router.Use(h.replayMiddleware)
 	}
-	router.Use(h.bodyReadingMiddleware)
 
-	router.Path(`/`).Methods("GET", "HEAD").HandlerFunc(serviceHandler.get)
+	if h.Metrics != nil && h.ServeMetrics {
+		router.Path(`/metrics`).Methods("GET").Handler(h.Metrics.Handler()).Name(metricsOperation)
+	}
+	router.Path(`/`).Methods("GET", "HEAD").HandlerFunc(serviceHandler.get).Name("ListBuckets")
+	if h.STS != nil {
+		stsHandler := &stsHandler{
+			issuer: h.STS,
+			logger: h.logger,
+		}
+		router.Path(`/`).Methods("POST").HandlerFunc(stsHandler.post).Name(assumeRoleOperation)
+	}
 
 	// Bucket-related routes. Repo validation regex is the same that the aws
 	// cli uses. There's two routers - one with a trailing a slash and one
@@ -1,6 +1,7 @@
 package s2
 
 import (
+	"context"
 	"encoding/xml"
 	"net/http"
 	"time"
@@ -31,6 +32,13 @@ type ServiceController interface {
 	ListBuckets(r *http.Request) (*ListBucketsResult, error)
 }
 
+// ServiceContextController is a context-aware variant of
+// `ServiceController`
+type ServiceContextController interface {
+	// ListBuckets lists all buckets
+	ListBuckets(ctx context.Context, r *http.Request) (*ListBucketsResult, error)
+}
+
 // unimplementedServiceController defines a controller that returns
 // `NotImplementedError` for all functionality
 type unimplementedServiceController struct{}
@@ -40,12 +48,12 @@ func (c unimplementedServiceController) ListBuckets(r *http.Request) (*ListBucke
 }
 
 type serviceHandler struct {
-	controller ServiceController
+	controller ServiceContextController
 	logger     *logrus.Entry
 }
 
 func (h *serviceHandler) get(w http.ResponseWriter, r *http.Request) {
-	result, err := h.controller.ListBuckets(r)
+	result, err := h.controller.ListBuckets(r.Context(), r)
 	if err != nil {
 		WriteError(h.logger, w, r, err)
 		return
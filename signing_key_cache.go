@@ -0,0 +1,124 @@
+package s2
+
+import (
+	"container/list"
+	"sync"
+)
+
+// signingKeyCacheKey identifies a derived auth V4 signing key
+type signingKeyCacheKey struct {
+	accessKey string
+	date      string
+	region    string
+	service   string
+}
+
+// signingKeyCacheEntry is a cached signing key, along with the identity its
+// access key belongs to
+type signingKeyCacheEntry struct {
+	key        signingKeyCacheKey
+	signingKey []byte
+	identity   Identity
+}
+
+// SigningKeyCache is a bounded, least-recently-used cache of auth V4 signing
+// keys, keyed on access key, date, region and service. When set on `S2`,
+// requests whose signing key is cached skip both the secret key lookup and
+// the key derivation. Requests that include a session token are never
+// cached, so that their tokens are always validated.
+//
+// Because cached keys are used without consulting the auth controller,
+// `Invalidate` should be called whenever a secret key is rotated or revoked.
+// Otherwise, a cached key remains valid until it's evicted, or its date
+// passes. A `SigningKeyCache` is safe for concurrent use.
+type SigningKeyCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[signingKeyCacheKey]*list.Element
+	lru        *list.List
+}
+
+// NewSigningKeyCache creates a new signing key cache that holds at most
+// `maxEntries` signing keys
+func NewSigningKeyCache(maxEntries int) *SigningKeyCache {
+	return &SigningKeyCache{
+		maxEntries: maxEntries,
+		entries:    map[signingKeyCacheKey]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// Invalidate removes all cached signing keys of an access key. This should
+// be called when its secret key is rotated or revoked.
+func (c *SigningKeyCache) Invalidate(accessKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if key.accessKey == accessKey {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Purge removes all cached signing keys
+func (c *SigningKeyCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[signingKeyCacheKey]*list.Element{}
+	c.lru.Init()
+}
+
+// Len returns the number of cached signing keys
+func (c *SigningKeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// get returns a cached signing key, along with a copy of the identity its
+// access key belongs to
+func (c *SigningKeyCache) get(key signingKeyCacheKey) ([]byte, *Identity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*signingKeyCacheEntry)
+	identity := entry.identity
+	return entry.signingKey, &identity, true
+}
+
+// add caches a signing key, evicting the least recently used key if the
+// cache is full
+func (c *SigningKeyCache) add(key signingKeyCacheKey, signingKey []byte, identity *Identity) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &signingKeyCacheEntry{
+		key:        key,
+		signingKey: signingKey,
+		identity:   *identity,
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*signingKeyCacheEntry).key)
+	}
+}
@@ -0,0 +1,110 @@
+package s2
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultSessionDuration is the default lifetime of temporary
+	// credentials issued by the STS endpoint
+	defaultSessionDuration = time.Hour
+	// minSessionDuration is the minimum lifetime of temporary credentials
+	// that can be requested from the STS endpoint
+	minSessionDuration = 15 * time.Minute
+	// maxSessionDuration is the maximum lifetime of temporary credentials
+	// that can be requested from the STS endpoint
+	maxSessionDuration = 12 * time.Hour
+	// maxSTSRequestBodyLength is the maximum body length of STS requests
+	// that don't specify `x-amz-content-sha256`, whose bodies are buffered
+	// during auth to compute the payload hash
+	maxSTSRequestBodyLength = 64 * 1024
+	// assumeRoleOperation is the name of the STS endpoint's route, which is
+	// the only one that accepts requests signed for the sts service
+	assumeRoleOperation = "AssumeRole"
+)
+
+// Credentials are temporary security credentials issued by the STS endpoint
+type Credentials struct {
+	// AccessKeyID is the access key of the credentials
+	AccessKeyID string `xml:"AccessKeyId"`
+	// SecretAccessKey is the secret key of the credentials
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	// SessionToken is the token that clients must send alongside requests
+	// signed with the credentials, via the `x-amz-security-token` header
+	SessionToken string `xml:"SessionToken"`
+	// Expiration is when the credentials expire
+	Expiration time.Time `xml:"Expiration"`
+}
+
+// assumeRoleResponse is an XML marshallable response to an AssumeRole
+// request
+type assumeRoleResponse struct {
+	XMLName     xml.Name     `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleResponse"`
+	Credentials *Credentials `xml:"AssumeRoleResult>Credentials"`
+	RequestID   string       `xml:"ResponseMetadata>RequestId"`
+}
+
+// CredentialsIssuer is an interface defining the functionality of a minimal
+// STS-compatible endpoint, which issues temporary credentials. To accept
+// requests signed with the issued credentials, the auth controller should
+// implement `SessionAuthController`.
+type CredentialsIssuer interface {
+	// AssumeRole issues temporary credentials for the role `roleARN`, which
+	// should expire after `duration`. `roleARN` and `roleSessionName` may
+	// be empty. The identity of the caller, if auth is enabled, is
+	// available via `IdentityFromContext`.
+	AssumeRole(ctx context.Context, r *http.Request, roleARN, roleSessionName string, duration time.Duration) (*Credentials, error)
+}
+
+type stsHandler struct {
+	issuer CredentialsIssuer
+	logger *logrus.Entry
+}
+
+func (h *stsHandler) post(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("Action") != "AssumeRole" {
+		WriteError(h.logger, w, r, InvalidActionError(r))
+		return
+	}
+
+	duration := defaultSessionDuration
+	if durationStr := r.FormValue("DurationSeconds"); durationStr != "" {
+		seconds, err := strconv.Atoi(durationStr)
+		if err != nil {
+			WriteError(h.logger, w, r, ValidationError(r, "DurationSeconds must be an integer."))
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+		if duration < minSessionDuration || duration > maxSessionDuration {
+			WriteError(h.logger, w, r, ValidationError(r, "The requested DurationSeconds is out of range."))
+			return
+		}
+	}
+
+	roleARN := r.FormValue("RoleArn")
+	roleSessionName := r.FormValue("RoleSessionName")
+
+	credentials, err := h.issuer.AssumeRole(r.Context(), r, roleARN, roleSessionName, duration)
+	if err != nil {
+		WriteError(h.logger, w, r, err)
+		return
+	}
+	if credentials == nil {
+		WriteError(h.logger, w, r, InternalError(r, errors.New("no credentials were issued")))
+		return
+	}
+
+	credentials.Expiration = credentials.Expiration.UTC().Round(time.Second)
+
+	writeXML(h.logger, w, r, http.StatusOK, &assumeRoleResponse{
+		Credentials: credentials,
+		RequestID:   RequestIDFromContext(r.Context()),
+	})
+}
@@ -0,0 +1,287 @@
+package s2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stsTestIssuer is a `CredentialsIssuer` that issues fixed credentials
+type stsTestIssuer struct {
+	durations []time.Duration
+}
+
+func (i *stsTestIssuer) AssumeRole(ctx context.Context, r *http.Request, roleARN, roleSessionName string, duration time.Duration) (*Credentials, error) {
+	i.durations = append(i.durations, duration)
+	return &Credentials{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func TestSTSAssumeRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     string
+		code     int
+		duration time.Duration
+	}{
+		{
+			name:     "default duration",
+			form:     "Action=AssumeRole&Version=2011-06-15",
+			code:     http.StatusOK,
+			duration: time.Hour,
+		},
+		{
+			name:     "custom duration",
+			form:     "Action=AssumeRole&Version=2011-06-15&DurationSeconds=900",
+			code:     http.StatusOK,
+			duration: 15 * time.Minute,
+		},
+		{
+			name: "duration out of range",
+			form: "Action=AssumeRole&Version=2011-06-15&DurationSeconds=60",
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid action",
+			form: "Action=GetSessionToken&Version=2011-06-15",
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := &stsTestIssuer{}
+			s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+			s.STS = issuer
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/", strings.NewReader(test.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			s.Router().ServeHTTP(rec, req)
+
+			if rec.Code != test.code {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+
+			if len(issuer.durations) != 1 || issuer.durations[0] != test.duration {
+				t.Errorf("unexpected durations: %v", issuer.durations)
+			}
+			var response assumeRoleResponse
+			if err := xml.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("could not unmarshal response: %v", err)
+			}
+			if response.Credentials == nil || response.Credentials.AccessKeyID != "key" || response.Credentials.SessionToken != "token" {
+				t.Errorf("unexpected response: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// stsNilTestIssuer is a `CredentialsIssuer` that issues no credentials,
+// without returning an error
+type stsNilTestIssuer struct{}
+
+func (i stsNilTestIssuer) AssumeRole(ctx context.Context, r *http.Request, roleARN, roleSessionName string, duration time.Duration) (*Credentials, error) {
+	return nil, nil
+}
+
+func TestSTSNilCredentials(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewS2(logrus.NewEntry(logger), 0, 5*time.Second)
+	s.STS = stsNilTestIssuer{}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", strings.NewReader("Action=AssumeRole&Version=2011-06-15"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "<Code>InternalError</Code>") {
+		t.Errorf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// stsTestAuthController is an `AuthContextController` that implements
+// `SessionAuthController`, with a long-term access key and the temporary
+// credentials issued by `stsTestIssuer`
+type stsTestAuthController struct {
+	AuthContextController
+}
+
+func (c stsTestAuthController) SecretKeyIdentity(ctx context.Context, r *http.Request, accessKey string, region *string) (*string, *Identity, error) {
+	// the access keys of temporary credentials aren't accepted without
+	// their session token
+	if accessKey != testAccessKey {
+		return nil, nil, nil
+	}
+	secretKey := testSecretKey
+	return &secretKey, nil, nil
+}
+
+func (c stsTestAuthController) CustomAuthIdentity(ctx context.Context, r *http.Request) (*Identity, error) {
+	return nil, nil
+}
+
+func (c stsTestAuthController) SessionSecretKey(ctx context.Context, r *http.Request, accessKey, sessionToken string, region *string) (*string, *Identity, error) {
+	if accessKey != "key" {
+		return nil, nil, nil
+	}
+	if sessionToken != "token" {
+		return nil, nil, InvalidTokenError(r)
+	}
+	secretKey := "secret"
+	return &secretKey, nil, nil
+}
+
+// signV4Service signs a request using AWS' auth V4 for the given service,
+// signing the host, `x-amz-date` and `x-amz-security-token` (if set)
+// headers. As STS doesn't require it, the payload hash is only sent for
+// other services.
+func signV4Service(r *http.Request, accessKey, secretKey, service, body string, timestamp time.Time) {
+	formattedTimestamp := formatAWSTimestamp(timestamp)
+	date := timestamp.Format("20060102")
+	r.Header.Set("x-amz-date", formattedTimestamp)
+	if service != "sts" {
+		r.Header.Set("x-amz-content-sha256", sha256Hex(body))
+	}
+
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-date:%s\n", r.Host, formattedTimestamp)
+	signedHeaders := "host;x-amz-date"
+	if token := r.Header.Get("x-amz-security-token"); token != "" {
+		canonicalHeaders += fmt.Sprintf("x-amz-security-token:%s\n", token)
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		normURI(r.URL.Path),
+		normQuery(r.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	stringToSign := fmt.Sprintf(
+		"AWS4-HMAC-SHA256\n%s\n%s/%s/%s/aws4_request\n%x",
+		formattedTimestamp, date, testRegion, service, sha256.Sum256([]byte(canonicalRequest)),
+	)
+
+	signingKey := signingKeyV4(secretKey, date, testRegion, service)
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s/%s/%s/aws4_request, SignedHeaders=%s, Signature=%x",
+		accessKey, date, testRegion, service, signedHeaders, hmacSHA256(signingKey, stringToSign),
+	))
+}
+
+func TestSTSAuth(t *testing.T) {
+	form := "Action=AssumeRole&Version=2011-06-15"
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		body      string
+		service   string
+		accessKey string
+		secretKey string
+		token     string
+		code      string
+	}{
+		{
+			name:    "sts scope on the sts endpoint",
+			method:  "POST",
+			target:  "/",
+			body:    form,
+			service: "sts",
+		},
+		{
+			name:    "sts scope on an s3 route",
+			method:  "GET",
+			target:  "/bucket/key",
+			service: "sts",
+			code:    "AuthorizationHeaderMalformed",
+		},
+		{
+			name:    "sts endpoint body too large",
+			method:  "POST",
+			target:  "/",
+			body:    form + "&RoleSessionName=" + strings.Repeat("a", maxSTSRequestBodyLength),
+			service: "sts",
+			code:    "EntityTooLarge",
+		},
+		{
+			name:      "temporary credentials",
+			method:    "GET",
+			target:    "/bucket/key",
+			service:   "s3",
+			accessKey: "key",
+			secretKey: "secret",
+			token:     "token",
+		},
+		{
+			name:      "temporary credentials with an invalid token",
+			method:    "GET",
+			target:    "/bucket/key",
+			service:   "s3",
+			accessKey: "key",
+			secretKey: "secret",
+			token:     "invalid",
+			code:      "InvalidToken",
+		},
+		{
+			name:      "temporary credentials without a token",
+			method:    "GET",
+			target:    "/bucket/key",
+			service:   "s3",
+			accessKey: "key",
+			secretKey: "secret",
+			code:      "InvalidAccessKeyId",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+			s.AuthContext = stsTestAuthController{}
+			s.Object = authTestObjectController{}
+			s.STS = &stsTestIssuer{}
+
+			accessKey, secretKey := test.accessKey, test.secretKey
+			if accessKey == "" {
+				accessKey, secretKey = testAccessKey, testSecretKey
+			}
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			if test.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.token != "" {
+				req.Header.Set("x-amz-security-token", test.token)
+			}
+			signV4Service(req, accessKey, secretKey, test.service, test.body, time.Now())
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, req)
+
+			if test.code == "" {
+				if rec.Code != http.StatusOK {
+					t.Errorf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+				}
+			} else if !strings.Contains(rec.Body.String(), "<Code>"+test.code+"</Code>") {
+				t.Errorf("unexpected response %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package s2
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the instrumentation name of the tracer s2 creates spans
+	// with
+	tracerName = "github.com/pachyderm/s2"
+	// requestIDAttribute is the span attribute holding the request ID
+	requestIDAttribute = attribute.Key("s2.request_id")
+	// errorCodeAttribute is the span attribute holding the S3 error code
+	// of a failed request or controller call
+	errorCodeAttribute = attribute.Key("s2.error_code")
+	// bucketAttribute is the span attribute holding the bucket a
+	// controller call is for
+	bucketAttribute = attribute.Key("s3.bucket")
+	// keyAttribute is the span attribute holding the object key a
+	// controller call is for
+	keyAttribute = attribute.Key("s3.key")
+)
+
+// errorCode gets the S3 error code that an error is returned to clients as
+func errorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return "InternalError"
+}
+
+// endSpan ends a span, recording `err` on it if it's non-nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(errorCodeAttribute.String(errorCode(err)))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingMiddleware creates a middleware for tracing requests. Each request
+// is traced as a server span named after its S3 operation, which continues
+// the W3C trace context of the request, if there is one.
+func (h *S2) tracingMiddleware(tracer trace.Tracer) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			operation := operationName(r)
+			if operation == metricsOperation {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, operation,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					requestIDAttribute.String(RequestIDFromContext(ctx)),
+					attribute.String("http.method", r.Method),
+				),
+			)
+			defer span.End()
+
+			r, errorCode := withErrorCodeRecorder(r.WithContext(ctx))
+			cw := &countingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+
+			span.SetAttributes(attribute.Int("http.status_code", cw.statusCode()))
+			if *errorCode != "" {
+				span.SetAttributes(errorCodeAttribute.String(*errorCode))
+				span.SetStatus(codes.Error, *errorCode)
+			}
+		})
+	}
+}
+
+// traceMiddleware wraps a middleware so that its own work, i.e. everything
+// up until it calls the next handler, is traced as a span. If the
+// middleware responds with an error instead, its S3 error code is recorded
+// on the span. Handlers further down the chain are traced as children of
+// the request's span, rather than of the middleware's.
+func traceMiddleware(tracer trace.Tracer, name string, middleware mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, errorCode := withErrorCodeRecorder(r)
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := tracer.Start(r.Context(), name)
+
+			calledNext := false
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calledNext = true
+				span.End()
+				next.ServeHTTP(w, r.WithContext(trace.ContextWithSpan(r.Context(), parent)))
+			}))
+			handler.ServeHTTP(w, r.WithContext(ctx))
+
+			if !calledNext {
+				if *errorCode != "" {
+					span.SetAttributes(errorCodeAttribute.String(*errorCode))
+					span.SetStatus(codes.Error, *errorCode)
+				}
+				span.End()
+			}
+		})
+	}
+}
+
+// tracedServiceController is a `ServiceContextController` that traces
+// every call as a span
+type tracedServiceController struct {
+	controller ServiceContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedServiceController) ListBuckets(ctx context.Context, r *http.Request) (*ListBucketsResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ServiceController.ListBuckets")
+	result, err := c.controller.ListBuckets(ctx, r)
+	endSpan(span, err)
+	return result, err
+}
+
+// tracedBucketController is a `BucketContextController` that traces every
+// call as a span
+type tracedBucketController struct {
+	controller BucketContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedBucketController) GetLocation(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "BucketController.GetLocation", trace.WithAttributes(bucketAttribute.String(bucket)))
+	location, err := c.controller.GetLocation(ctx, r, bucket)
+	endSpan(span, err)
+	return location, err
+}
+
+func (c tracedBucketController) ListObjects(ctx context.Context, r *http.Request, bucket, prefix, marker, delimiter string, maxKeys int) (*ListObjectsResult, error) {
+	ctx, span := c.tracer.Start(ctx, "BucketController.ListObjects", trace.WithAttributes(bucketAttribute.String(bucket)))
+	result, err := c.controller.ListObjects(ctx, r, bucket, prefix, marker, delimiter, maxKeys)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedBucketController) ListObjectVersions(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, versionMarker string, delimiter string, maxKeys int) (*ListObjectVersionsResult, error) {
+	ctx, span := c.tracer.Start(ctx, "BucketController.ListObjectVersions", trace.WithAttributes(bucketAttribute.String(bucket)))
+	result, err := c.controller.ListObjectVersions(ctx, r, bucket, prefix, keyMarker, versionMarker, delimiter, maxKeys)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedBucketController) CreateBucket(ctx context.Context, r *http.Request, bucket string) error {
+	ctx, span := c.tracer.Start(ctx, "BucketController.CreateBucket", trace.WithAttributes(bucketAttribute.String(bucket)))
+	err := c.controller.CreateBucket(ctx, r, bucket)
+	endSpan(span, err)
+	return err
+}
+
+func (c tracedBucketController) DeleteBucket(ctx context.Context, r *http.Request, bucket string) error {
+	ctx, span := c.tracer.Start(ctx, "BucketController.DeleteBucket", trace.WithAttributes(bucketAttribute.String(bucket)))
+	err := c.controller.DeleteBucket(ctx, r, bucket)
+	endSpan(span, err)
+	return err
+}
+
+func (c tracedBucketController) GetBucketVersioning(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "BucketController.GetBucketVersioning", trace.WithAttributes(bucketAttribute.String(bucket)))
+	status, err := c.controller.GetBucketVersioning(ctx, r, bucket)
+	endSpan(span, err)
+	return status, err
+}
+
+func (c tracedBucketController) SetBucketVersioning(ctx context.Context, r *http.Request, bucket, status string) error {
+	ctx, span := c.tracer.Start(ctx, "BucketController.SetBucketVersioning", trace.WithAttributes(bucketAttribute.String(bucket)))
+	err := c.controller.SetBucketVersioning(ctx, r, bucket, status)
+	endSpan(span, err)
+	return err
+}
+
+// tracedHeadBucketController is a `HeadBucketContextController` that traces
+// every call as a span
+type tracedHeadBucketController struct {
+	controller HeadBucketContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedHeadBucketController) HeadBucket(ctx context.Context, r *http.Request, bucket string) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "BucketController.HeadBucket", trace.WithAttributes(bucketAttribute.String(bucket)))
+	region, err := c.controller.HeadBucket(ctx, r, bucket)
+	endSpan(span, err)
+	return region, err
+}
+
+// tracedObjectController is an `ObjectContextController` that traces every
+// call as a span
+type tracedObjectController struct {
+	controller ObjectContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedObjectController) GetObject(ctx context.Context, r *http.Request, bucket, key, version string) (*GetObjectResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.GetObject", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.GetObject(ctx, r, bucket, key, version)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedObjectController) CopyObject(ctx context.Context, r *http.Request, srcBucket, srcKey string, getResult *GetObjectResult, destBucket, destKey string, attrs *ObjectAttributes) (*CopyObjectResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.CopyObject", trace.WithAttributes(bucketAttribute.String(destBucket), keyAttribute.String(destKey)))
+	result, err := c.controller.CopyObject(ctx, r, srcBucket, srcKey, getResult, destBucket, destKey, attrs)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedObjectController) PutObject(ctx context.Context, r *http.Request, bucket, key string, reader io.Reader, attrs *ObjectAttributes, precondition *WritePrecondition) (*PutObjectResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.PutObject", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.PutObject(ctx, r, bucket, key, reader, attrs, precondition)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedObjectController) DeleteObject(ctx context.Context, r *http.Request, bucket, key, version string) (*DeleteObjectResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.DeleteObject", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.DeleteObject(ctx, r, bucket, key, version)
+	endSpan(span, err)
+	return result, err
+}
+
+// tracedObjectPartsController is an `ObjectPartsContextController` that
+// traces every call as a span
+type tracedObjectPartsController struct {
+	controller ObjectPartsContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedObjectPartsController) GetObjectParts(ctx context.Context, r *http.Request, bucket, key, version string) ([]*ObjectPart, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.GetObjectParts", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	parts, err := c.controller.GetObjectParts(ctx, r, bucket, key, version)
+	endSpan(span, err)
+	return parts, err
+}
+
+// tracedHeadObjectController is a `HeadObjectContextController` that traces
+// every call as a span
+type tracedHeadObjectController struct {
+	controller HeadObjectContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedHeadObjectController) HeadObject(ctx context.Context, r *http.Request, bucket, key, version string) (*HeadObjectResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ObjectController.HeadObject", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.HeadObject(ctx, r, bucket, key, version)
+	endSpan(span, err)
+	return result, err
+}
+
+// tracedMultipartController is a `MultipartContextController` that traces
+// every call as a span
+type tracedMultipartController struct {
+	controller MultipartContextController
+	tracer     trace.Tracer
+}
+
+func (c tracedMultipartController) ListMultipart(ctx context.Context, r *http.Request, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (*ListMultipartResult, error) {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.ListMultipart", trace.WithAttributes(bucketAttribute.String(bucket)))
+	result, err := c.controller.ListMultipart(ctx, r, bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedMultipartController) InitMultipart(ctx context.Context, r *http.Request, bucket, key string, attrs *ObjectAttributes) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.InitMultipart", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	uploadID, err := c.controller.InitMultipart(ctx, r, bucket, key, attrs)
+	endSpan(span, err)
+	return uploadID, err
+}
+
+func (c tracedMultipartController) AbortMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string) error {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.AbortMultipart", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	err := c.controller.AbortMultipart(ctx, r, bucket, key, uploadID)
+	endSpan(span, err)
+	return err
+}
+
+func (c tracedMultipartController) CompleteMultipart(ctx context.Context, r *http.Request, bucket, key, uploadID string, parts []*Part, checksum *Checksum, precondition *WritePrecondition) (*CompleteMultipartResult, error) {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.CompleteMultipart", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.CompleteMultipart(ctx, r, bucket, key, uploadID, parts, checksum, precondition)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedMultipartController) ListMultipartChunks(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListMultipartChunksResult, error) {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.ListMultipartChunks", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	result, err := c.controller.ListMultipartChunks(ctx, r, bucket, key, uploadID, partNumberMarker, maxParts)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c tracedMultipartController) UploadMultipartChunk(ctx context.Context, r *http.Request, bucket, key, uploadID string, partNumber int, reader io.Reader, checksum *Checksum) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "MultipartController.UploadMultipartChunk", trace.WithAttributes(bucketAttribute.String(bucket), keyAttribute.String(key)))
+	etag, err := c.controller.UploadMultipartChunk(ctx, r, bucket, key, uploadID, partNumber, reader, checksum)
+	endSpan(span, err)
+	return etag, err
+}
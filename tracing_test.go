@@ -0,0 +1,107 @@
+package s2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracingTestS2 creates an S2 instance with auth enabled, whose spans are
+// exported to an in-memory exporter
+func newTracingTestS2() (*S2, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	s := newAuthTestS2(&authTestController{secretKey: testSecretKey}, nil)
+	s.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return s, exporter
+}
+
+// spanAttribute gets the value of a span's attribute, or an empty string if
+// it's not set
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	s, exporter := newTracingTestS2()
+
+	r := httptest.NewRequest("GET", "/bucket/key", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	signV4(r, testAccessKey, testSecretKey, time.Now())
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	if len(spans) != 4 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+
+	root, ok := spans["GetObject"]
+	if !ok {
+		t.Fatalf("missing request span")
+	}
+	if root.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace ID: %s", root.SpanContext.TraceID())
+	}
+	if root.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected parent span ID: %s", root.Parent.SpanID())
+	}
+	if spanAttribute(root, requestIDAttribute) == "" {
+		t.Errorf("missing request ID")
+	}
+
+	for _, name := range []string{"authMiddleware", "bodyReadingMiddleware", "ObjectController.GetObject"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %q", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("span %q is not a child of the request span", name)
+		}
+	}
+}
+
+func TestTracingError(t *testing.T) {
+	s, exporter := newTracingTestS2()
+
+	r := httptest.NewRequest("GET", "/bucket/key", nil)
+	signV4(r, testAccessKey, "wrong", time.Now())
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	for _, span := range spans {
+		if span.Status.Code != codes.Error {
+			t.Errorf("unexpected status of span %q: %v", span.Name, span.Status)
+		}
+		if code := spanAttribute(span, errorCodeAttribute); code != "SignatureDoesNotMatch" {
+			t.Errorf("unexpected error code of span %q: %q", span.Name, code)
+		}
+		if span.Name == "GetObject" && spanAttribute(span, requestIDAttribute) != rec.Header().Get("x-amz-request-id") {
+			t.Errorf("unexpected request ID: %q", spanAttribute(span, requestIDAttribute))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package s2
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// canonicalRequestV4 constructs the canonical request of AWS' auth V4.
+// `signedHeaderKeys` are the lowercase names of the signed headers, sorted.
+func canonicalRequestV4(r *http.Request, signedHeaderKeys []string, payloadHash string) string {
+	var signedHeaders strings.Builder
+	for _, key := range signedHeaderKeys {
+		signedHeaders.WriteString(key)
+		signedHeaders.WriteString(":")
+		if key == "host" {
+			// go moves the host header out of the header map
+			signedHeaders.WriteString(r.Host)
+		} else {
+			signedHeaders.WriteString(normHeaderValues(r.Header[http.CanonicalHeaderKey(key)]))
+		}
+		signedHeaders.WriteString("\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		normURI(r.URL.Path),
+		normQuery(r.URL.Query()),
+		signedHeaders.String(),
+		strings.Join(signedHeaderKeys, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// stringToSignV4 constructs the string to sign of AWS' auth V4
+func stringToSignV4(canonicalRequest, timestamp, date, region, service string) string {
+	return fmt.Sprintf(
+		"AWS4-HMAC-SHA256\n%s\n%s/%s/%s/aws4_request\n%x",
+		timestamp,
+		date,
+		region,
+		service,
+		sha256.Sum256([]byte(canonicalRequest)),
+	)
+}
+
+// signingKeyV4 derives the signing key of AWS' auth V4 from a secret key
+func signingKeyV4(secretKey, date, region, service string) []byte {
+	dateKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	dateRegionKey := hmacSHA256(dateKey, region)
+	dateRegionServiceKey := hmacSHA256(dateRegionKey, service)
+	return hmacSHA256(dateRegionServiceKey, "aws4_request")
+}
+
+// stringsToSignV2 constructs the strings to sign of AWS' auth V2 that a
+// request's signature may match. If `x-amz-date` is set, AWS' documentation
+// describes signing it both in place of the date, and as one of the
+// canonicalized amz headers with an empty date; S3 accepts either.
+func stringsToSignV2(r *http.Request) []string {
+	amzDate := r.Header.Get("x-amz-date")
+	if amzDate == "" {
+		return []string{stringToSignV2(r, r.Header.Get("date"), true)}
+	}
+	return []string{
+		stringToSignV2(r, "", true),
+		stringToSignV2(r, amzDate, false),
+	}
+}
+
+// stringToSignV2 constructs a string to sign of AWS' auth V2, with the given
+// date. `signAmzDate` specifies whether `x-amz-date` is signed as one of the
+// canonicalized amz headers.
+func stringToSignV2(r *http.Request, date string, signAmzDate bool) string {
+	stringToSignParts := []string{
+		r.Method,
+		r.Header.Get("content-md5"),
+		r.Header.Get("content-type"),
+		date,
+	}
+
+	amzHeaderKeys := []string{}
+	for key := range r.Header {
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, "x-amz-") && (signAmzDate || lowerKey != "x-amz-date") {
+			amzHeaderKeys = append(amzHeaderKeys, key)
+		}
+	}
+	sort.Slice(amzHeaderKeys, func(i, j int) bool {
+		return strings.ToLower(amzHeaderKeys[i]) < strings.ToLower(amzHeaderKeys[j])
+	})
+	for _, key := range amzHeaderKeys {
+		values := make([]string, len(r.Header[key]))
+		for i, value := range r.Header[key] {
+			values[i] = strings.TrimSpace(value)
+		}
+		stringToSignParts = append(stringToSignParts, fmt.Sprintf("%s:%s", strings.ToLower(key), strings.Join(values, ",")))
+	}
+
+	// the resource is signed as sent, i.e. URI-encoded, followed by the
+	// subresources. Subresources with multiple values only have their
+	// first value signed, as in AWS' reference signers.
+	var canonicalizedResource strings.Builder
+	canonicalizedResource.WriteString(r.URL.EscapedPath())
+	query := r.URL.Query()
+	appendedQuery := false
+	for _, k := range subresourceQueryParams {
+		if _, ok := query[k]; !ok {
+			continue
+		}
+		if appendedQuery {
+			canonicalizedResource.WriteString("&")
+		} else {
+			canonicalizedResource.WriteString("?")
+			appendedQuery = true
+		}
+
+		canonicalizedResource.WriteString(k)
+		if value := query.Get(k); value != "" {
+			canonicalizedResource.WriteString("=")
+			canonicalizedResource.WriteString(value)
+		}
+	}
+	stringToSignParts = append(stringToSignParts, canonicalizedResource.String())
+
+	return strings.Join(stringToSignParts, "\n")
+}
@@ -0,0 +1,369 @@
+package s2
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"net/http"
+	"strings"
+)
+
+// crc64NVMEPolynomial is the (reversed) polynomial of the CRC64NVME
+// checksum algorithm
+const crc64NVMEPolynomial = 0x9a6c9329ac4bc9b5
+
+var (
+	crc32CTable    = crc32.MakeTable(crc32.Castagnoli)
+	crc64NVMETable = crc64.MakeTable(crc64NVMEPolynomial)
+
+	// checksumAlgorithms maps supported checksum algorithms to hash
+	// constructors
+	checksumAlgorithms = map[string]func() hash.Hash{
+		ChecksumCRC32:     func() hash.Hash { return crc32.NewIEEE() },
+		ChecksumCRC32C:    func() hash.Hash { return crc32.New(crc32CTable) },
+		ChecksumCRC64NVME: func() hash.Hash { return crc64.New(crc64NVMETable) },
+		ChecksumSHA1:      sha1.New,
+		ChecksumSHA256:    sha256.New,
+	}
+
+	// crcPolynomials maps CRC checksum algorithms to their (reversed)
+	// polynomials, for combining checksums
+	crcPolynomials = map[string]uint64{
+		ChecksumCRC32:     crc32.IEEE,
+		ChecksumCRC32C:    crc32.Castagnoli,
+		ChecksumCRC64NVME: crc64NVMEPolynomial,
+	}
+)
+
+// checksumHeader returns the name of the header that holds checksums of the
+// given algorithm
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// normChecksumAlgorithm normalizes a checksum algorithm name, returning an
+// empty string if it's unsupported
+func normChecksumAlgorithm(algorithm string) string {
+	algorithm = strings.ToUpper(algorithm)
+	if _, ok := checksumAlgorithms[algorithm]; !ok {
+		return ""
+	}
+	return algorithm
+}
+
+// decodeChecksum decodes a base64-encoded checksum, ensuring it's the right
+// length for the algorithm
+func decodeChecksum(algorithm, value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != checksumAlgorithms[algorithm]().Size() {
+		return nil, fmt.Errorf("invalid %s checksum length: %d", algorithm, len(decoded))
+	}
+	return decoded, nil
+}
+
+// computeChecksum computes the base64-encoded checksum of some content
+func computeChecksum(algorithm string, content []byte) string {
+	hash := checksumAlgorithms[algorithm]()
+	hash.Write(content)
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// checksumFromRequest parses the additional checksum of a request body from
+// the `x-amz-checksum-*` headers. It returns nil if no checksum was
+// specified, or if the checksum will be sent in a trailer.
+func checksumFromRequest(r *http.Request) (*Checksum, error) {
+	var checksum *Checksum
+	for algorithm := range checksumAlgorithms {
+		value := r.Header.Get(checksumHeader(algorithm))
+		if value == "" {
+			continue
+		}
+		if checksum != nil {
+			return nil, InvalidRequestError(r, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		if _, err := decodeChecksum(algorithm, value); err != nil {
+			return nil, InvalidRequestError(r, fmt.Sprintf("Value for x-amz-checksum-%s header is invalid.", strings.ToLower(algorithm)))
+		}
+		checksum = &Checksum{
+			Algorithm: algorithm,
+			Value:     value,
+			Type:      ChecksumTypeFullObject,
+		}
+	}
+
+	if sdkAlgorithm := r.Header.Get("x-amz-sdk-checksum-algorithm"); sdkAlgorithm != "" {
+		algorithm := normChecksumAlgorithm(sdkAlgorithm)
+		if algorithm == "" {
+			return nil, InvalidRequestError(r, "Value for x-amz-sdk-checksum-algorithm header is invalid.")
+		}
+		if checksum == nil && r.Header.Get("x-amz-trailer") == "" {
+			return nil, InvalidRequestError(r, "x-amz-sdk-checksum-algorithm specified, but no corresponding x-amz-checksum-* or x-amz-trailer headers were found.")
+		}
+		if checksum != nil && checksum.Algorithm != algorithm {
+			return nil, InvalidRequestError(r, "Value for x-amz-sdk-checksum-algorithm header is invalid.")
+		}
+	}
+
+	return checksum, nil
+}
+
+// multipartChecksumFromRequest parses the additional checksum algorithm and
+// type of a new multipart upload. It returns nil if no algorithm was
+// specified.
+func multipartChecksumFromRequest(r *http.Request) (*Checksum, error) {
+	algorithmHeader := r.Header.Get("x-amz-checksum-algorithm")
+	if algorithmHeader == "" {
+		return nil, nil
+	}
+	algorithm := normChecksumAlgorithm(algorithmHeader)
+	if algorithm == "" {
+		return nil, InvalidRequestError(r, "Checksum algorithm provided is unsupported.")
+	}
+
+	checksumType := strings.ToUpper(r.Header.Get("x-amz-checksum-type"))
+	switch checksumType {
+	case "":
+		checksumType = defaultMultipartChecksumType(algorithm)
+	case ChecksumTypeComposite:
+		if algorithm == ChecksumCRC64NVME {
+			return nil, InvalidRequestError(r, "The COMPOSITE checksum type cannot be used with the CRC64NVME checksum algorithm.")
+		}
+	case ChecksumTypeFullObject:
+		if _, ok := crcPolynomials[algorithm]; !ok {
+			return nil, InvalidRequestError(r, fmt.Sprintf("The FULL_OBJECT checksum type cannot be used with the %s checksum algorithm.", algorithm))
+		}
+	default:
+		return nil, InvalidRequestError(r, "Value for x-amz-checksum-type header is invalid.")
+	}
+
+	return &Checksum{
+		Algorithm: algorithm,
+		Type:      checksumType,
+	}, nil
+}
+
+// defaultMultipartChecksumType returns the checksum type used for multipart
+// uploads with the given algorithm when none is specified
+func defaultMultipartChecksumType(algorithm string) string {
+	if algorithm == ChecksumCRC64NVME {
+		return ChecksumTypeFullObject
+	}
+	return ChecksumTypeComposite
+}
+
+// expectedMultipartChecksumFromRequest parses the expected additional
+// checksum of an object from the headers of a CompleteMultipartUpload
+// request. Unlike checksums of request bodies, composite checksum values
+// may have a part count suffix, so values are not validated here.
+func expectedMultipartChecksumFromRequest(r *http.Request) (*Checksum, error) {
+	var checksum *Checksum
+	for algorithm := range checksumAlgorithms {
+		value := r.Header.Get(checksumHeader(algorithm))
+		if value == "" {
+			continue
+		}
+		if checksum != nil {
+			return nil, InvalidRequestError(r, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		checksum = &Checksum{
+			Algorithm: algorithm,
+			Value:     value,
+			Type:      strings.ToUpper(r.Header.Get("x-amz-checksum-type")),
+		}
+	}
+	return checksum, nil
+}
+
+// multipartChecksum computes the additional checksum of an object being
+// created by a CompleteMultipartUpload request. `uploadedParts` and
+// `listing` are the uploaded parts and the first page of their listing.
+// `partsAlgorithm` is the checksum algorithm used by the request's parts,
+// if any, and `expected` is the expected checksum of the object, if any.
+// It returns nil if the upload doesn't use additional checksums.
+func multipartChecksum(r *http.Request, parts []*Part, uploadedParts map[int]*Part, listing *ListMultipartChunksResult, partsAlgorithm string, expected *Checksum) (*Checksum, error) {
+	algorithm := partsAlgorithm
+	checksumType := ""
+	if listing.ChecksumAlgorithm != "" {
+		if algorithm != "" && algorithm != listing.ChecksumAlgorithm {
+			return nil, InvalidRequestError(r, fmt.Sprintf("The upload was created using the %s checksum algorithm.", listing.ChecksumAlgorithm))
+		}
+		algorithm = listing.ChecksumAlgorithm
+		checksumType = listing.ChecksumType
+	}
+	if expected != nil {
+		if algorithm != "" && algorithm != expected.Algorithm {
+			return nil, InvalidRequestError(r, fmt.Sprintf("The upload was created using the %s checksum algorithm.", algorithm))
+		}
+		algorithm = expected.Algorithm
+		if checksumType == "" {
+			checksumType = expected.Type
+		}
+	}
+	if algorithm == "" {
+		return nil, nil
+	}
+	if checksumType == "" {
+		checksumType = defaultMultipartChecksumType(algorithm)
+	}
+
+	// checksums specified in the request must match those of the uploaded
+	// parts. Controllers that don't store part checksums fall back to the
+	// request's, which were validated when the parts were uploaded.
+	checksumParts := make([]*Part, len(parts))
+	for i, part := range parts {
+		uploadedPart, ok := uploadedParts[part.PartNumber]
+		if !ok {
+			return nil, InvalidPartError(r)
+		}
+		value := uploadedPart.Checksum(algorithm)
+		if requestValue := part.Checksum(algorithm); value == "" {
+			value = requestValue
+		} else if requestValue != "" && requestValue != value {
+			return nil, InvalidPartError(r)
+		}
+		if value == "" {
+			return nil, InvalidPartError(r)
+		}
+		checksumPart := &Part{PartNumber: part.PartNumber, Size: uploadedPart.Size}
+		checksumPart.SetChecksum(&Checksum{Algorithm: algorithm, Value: value})
+		checksumParts[i] = checksumPart
+	}
+
+	checksum, err := MultipartChecksum(checksumParts, algorithm, checksumType)
+	if err != nil {
+		return nil, InvalidRequestError(r, err.Error())
+	}
+
+	if expected != nil && expected.Value != checksum.Value && expected.Value != strings.SplitN(checksum.Value, "-", 2)[0] {
+		return nil, BadDigestError(r)
+	}
+
+	return checksum, nil
+}
+
+// writeChecksumHeaders writes the headers for an additional checksum, if
+// it has a value
+func writeChecksumHeaders(w http.ResponseWriter, checksum *Checksum) {
+	if checksum == nil || checksum.Value == "" {
+		return
+	}
+	w.Header().Set(checksumHeader(checksum.Algorithm), checksum.Value)
+	if checksum.Type != "" {
+		w.Header().Set("x-amz-checksum-type", checksum.Type)
+	}
+}
+
+// checksumElements is an XML marshallable representation of an additional
+// checksum, for embedding in responses
+type checksumElements struct {
+	ChecksumCRC32     string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C    string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumCRC64NVME string `xml:"ChecksumCRC64NVME,omitempty"`
+	ChecksumSHA1      string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256    string `xml:"ChecksumSHA256,omitempty"`
+	ChecksumType      string `xml:"ChecksumType,omitempty"`
+}
+
+func newChecksumElements(checksum *Checksum) checksumElements {
+	if checksum == nil {
+		return checksumElements{}
+	}
+	part := Part{}
+	part.SetChecksum(checksum)
+	return checksumElements{
+		ChecksumCRC32:     part.ChecksumCRC32,
+		ChecksumCRC32C:    part.ChecksumCRC32C,
+		ChecksumCRC64NVME: part.ChecksumCRC64NVME,
+		ChecksumSHA1:      part.ChecksumSHA1,
+		ChecksumSHA256:    part.ChecksumSHA256,
+		ChecksumType:      checksum.Type,
+	}
+}
+
+// crcFromBytes decodes a big-endian CRC
+func crcFromBytes(b []byte) uint64 {
+	var crc uint64
+	for _, c := range b {
+		crc = crc<<8 | uint64(c)
+	}
+	return crc
+}
+
+// crcToBytes encodes a CRC of `size` bytes as big-endian
+func crcToBytes(crc uint64, size int) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(crc)
+		crc >>= 8
+	}
+	return b
+}
+
+// crcCombine computes the CRC of two concatenated blocks of data, given
+// the CRC of each block and the length of the second. `poly` is the
+// reversed polynomial of the CRC, and `width` is its size in bits. This is
+// adapted from zlib's `crc32_combine`.
+func crcCombine(poly uint64, width int, crc1, crc2 uint64, len2 int64) uint64 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	// odd is the operator for one zero bit, even for two zero bits
+	even := make([]uint64, width)
+	odd := make([]uint64, width)
+	odd[0] = poly
+	row := uint64(1)
+	for n := 1; n < width; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(even, odd)
+	gf2MatrixSquare(odd, even)
+
+	// apply len2 zero bytes to crc1, squaring the operator for each bit of
+	// len2
+	for {
+		gf2MatrixSquare(even, odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(odd, even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat []uint64, vec uint64) uint64 {
+	var sum uint64
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat []uint64) {
+	for n := range mat {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
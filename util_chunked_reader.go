@@ -3,34 +3,88 @@ package s2
 import (
 	"bufio"
 	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+)
+
+const (
+	// unsignedPayload is the `x-amz-content-sha256` value of auth V4
+	// requests whose payload isn't signed
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// streamingPayload is the `x-amz-content-sha256` value of signed
+	// multi-chunk uploads
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// streamingPayloadTrailer is the `x-amz-content-sha256` value of signed
+	// multi-chunk uploads with trailing headers
+	streamingPayloadTrailer = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	// streamingUnsignedPayloadTrailer is the `x-amz-content-sha256` value
+	// of unsigned multi-chunk uploads with trailing headers
+	streamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
 )
 
 var (
 	// chunkValidator is a regexp for validating a chunk "header" in the
 	// request body of a multi-chunk upload
 	chunkValidator = regexp.MustCompile(`^([0-9a-fA-F]+);chunk-signature=([0-9a-fA-F]+)`)
+	// unsignedChunkValidator is a regexp for validating a chunk "header" in
+	// the request body of an unsigned multi-chunk upload
+	unsignedChunkValidator = regexp.MustCompile(`^([0-9a-fA-F]+)(;[^\r\n]*)?\r?\n$`)
 
 	// InvalidChunk is an error returned when reading a multi-chunk object
 	// upload that contains an invalid chunk header or body
 	InvalidChunk = errors.New("invalid chunk")
+	// InvalidChunkLength is an error returned when reading a multi-chunk
+	// object upload whose decoded length doesn't match its
+	// `x-amz-decoded-content-length` header
+	InvalidChunkLength = errors.New("invalid chunked upload length")
+	// InvalidChunkChecksum is an error returned when reading an object
+	// upload whose decoded contents don't match its additional checksum
+	InvalidChunkChecksum = errors.New("invalid chunked upload checksum")
 )
 
+// chunkSigner holds the auth V4 signing data of a request, which is reused
+// for verifying the chunk signatures of multi-chunk uploads
+type chunkSigner struct {
+	signingKey    []byte
+	seedSignature string
+	timestamp     string
+	date          string
+	region        string
+}
+
 // Reads a multi-chunk upload body
 type chunkedReader struct {
 	body      io.ReadCloser
 	lastChunk []byte
 	bufBody   *bufio.Reader
+	err       error
 
+	signed        bool
 	signingKey    []byte
 	lastSignature string
 	timestamp     string
 	date          string
 	region        string
+
+	// trailer is the name of the trailing header holding the additional
+	// checksum, if any
+	trailer string
+	// checksum is the additional checksum of the decoded contents. If it's
+	// sent as a trailing header, its value is set once it's read.
+	checksum *Checksum
+	hash     hash.Hash
+
+	// decodedLength is the expected length of the decoded contents, or -1
+	// if it's unknown
+	decodedLength int64
+	length        int64
 }
 
 func newChunkedReader(body io.ReadCloser, signingKey []byte, seedSignature, timestamp, date, region string) *chunkedReader {
@@ -39,17 +93,138 @@ func newChunkedReader(body io.ReadCloser, signingKey []byte, seedSignature, time
 		lastChunk: nil,
 		bufBody:   bufio.NewReader(body),
 
+		signed:        true,
 		signingKey:    signingKey,
 		lastSignature: seedSignature,
 		timestamp:     timestamp,
 		date:          date,
 		region:        region,
+
+		decodedLength: -1,
+	}
+}
+
+func newUnsignedChunkedReader(body io.ReadCloser) *chunkedReader {
+	return &chunkedReader{
+		body:      body,
+		lastChunk: nil,
+		bufBody:   bufio.NewReader(body),
+
+		decodedLength: -1,
+	}
+}
+
+// Reads a single-chunk upload body, verifying its additional checksum
+type checksumReader struct {
+	body     io.ReadCloser
+	checksum *Checksum
+	hash     hash.Hash
+}
+
+func newChecksumReader(body io.ReadCloser, checksum *Checksum) *checksumReader {
+	return &checksumReader{
+		body:     body,
+		checksum: checksum,
+		hash:     checksumAlgorithms[checksum.Algorithm](),
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = c.body.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && base64.StdEncoding.EncodeToString(c.hash.Sum(nil)) != c.checksum.Value {
+		return n, InvalidChunkChecksum
+	}
+	return n, err
+}
+
+func (c *checksumReader) Close() error {
+	return c.body.Close()
+}
+
+// chunkedBody wraps the body of a request in a `chunkedReader` if it's a
+// multi-chunk upload. `checksum` is the additional checksum specified in
+// the request headers, if any. It returns the body to read, and the
+// additional checksum of the decoded contents; for trailing checksums, the
+// checksum value is only set once the body has been fully read.
+func chunkedBody(r *http.Request, checksum *Checksum) (io.ReadCloser, *Checksum, error) {
+	payload := r.Header.Get("x-amz-content-sha256")
+
+	var c *chunkedReader
+	switch payload {
+	case streamingPayload, streamingPayloadTrailer:
+		// if the request wasn't authenticated via auth V4, the zero signer
+		// fails every chunk signature check
+		signer, ok := r.Context().Value(chunkSignerContextKey).(*chunkSigner)
+		if !ok {
+			signer = &chunkSigner{}
+		}
+		c = newChunkedReader(r.Body, signer.signingKey, signer.seedSignature, signer.timestamp, signer.date, signer.region)
+	case streamingUnsignedPayloadTrailer:
+		c = newUnsignedChunkedReader(r.Body)
+	default:
+		// bodies without a `Content-Length` aren't buffered and verified by
+		// `bodyReadingMiddleware`, so they're verified as they're read
+		verified, _ := r.Context().Value(bodyVerifiedContextKey).(bool)
+		if checksum != nil && !verified {
+			return newChecksumReader(r.Body, checksum), checksum, nil
+		}
+		return r.Body, checksum, nil
+	}
+
+	if trailer := strings.ToLower(r.Header.Get("x-amz-trailer")); trailer != "" && payload != streamingPayload {
+		algorithm := normChecksumAlgorithm(strings.TrimPrefix(trailer, "x-amz-checksum-"))
+		if !strings.HasPrefix(trailer, "x-amz-checksum-") || algorithm == "" {
+			return nil, nil, InvalidRequestError(r, "The value specified in the x-amz-trailer header is not supported.")
+		}
+		if checksum != nil {
+			return nil, nil, InvalidRequestError(r, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		c.trailer = trailer
+		checksum = &Checksum{
+			Algorithm: algorithm,
+			Type:      ChecksumTypeFullObject,
+		}
+	}
+	if checksum != nil {
+		c.checksum = checksum
+		c.hash = checksumAlgorithms[checksum.Algorithm]()
+	}
+
+	if decodedLengthStr := r.Header.Get("x-amz-decoded-content-length"); decodedLengthStr != "" {
+		decodedLength, err := strconv.ParseInt(decodedLengthStr, 10, 64)
+		if err != nil || decodedLength < 0 {
+			return nil, nil, InvalidArgumentError(r)
+		}
+		c.decodedLength = decodedLength
+	}
+
+	return c, checksum, nil
+}
+
+// chunkedReaderError converts an error from reading a multi-chunk upload
+// into the corresponding S3 error
+func chunkedReaderError(r *http.Request, err error) error {
+	switch err {
+	case InvalidChunk:
+		return SignatureDoesNotMatchError(r)
+	case InvalidChunkLength:
+		return IncompleteBodyError(r)
+	case InvalidChunkChecksum:
+		return BadDigestError(r)
+	default:
+		return err
 	}
 }
 
 func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
 	if c.lastChunk == nil {
 		if err := c.readChunk(); err != nil {
+			c.err = err
 			return 0, err
 		}
 	}
@@ -66,23 +241,30 @@ func (c *chunkedReader) Read(p []byte) (n int, err error) {
 }
 
 func (c *chunkedReader) readChunk() error {
-	// step 1: read the chunk header
+	// step 1: read the chunk header. The body must end with the final,
+	// empty chunk (and any trailing headers), so reaching the end of it
+	// here means the upload was truncated.
 	line, err := c.bufBody.ReadString('\n')
 	if err != nil {
-		if err == io.EOF {
-			return err
-		}
 		return InvalidChunk
 	}
 
-	match := chunkValidator.FindStringSubmatch(line)
-	if len(match) == 0 {
-		return InvalidChunk
+	var chunkLengthHexStr, chunkSignature string
+	if c.signed {
+		match := chunkValidator.FindStringSubmatch(line)
+		if len(match) == 0 {
+			return InvalidChunk
+		}
+		chunkLengthHexStr = match[1]
+		chunkSignature = match[2]
+	} else {
+		match := unsignedChunkValidator.FindStringSubmatch(line)
+		if len(match) == 0 {
+			return InvalidChunk
+		}
+		chunkLengthHexStr = match[1]
 	}
 
-	chunkLengthHexStr := match[1]
-	chunkSignature := match[2]
-
 	chunkLength, err := strconv.ParseUint(chunkLengthHexStr, 16, 32)
 	if err != nil {
 		return InvalidChunk
@@ -95,34 +277,128 @@ func (c *chunkedReader) readChunk() error {
 		return InvalidChunk
 	}
 
-	// step 3: read the trailer
+	// step 3: verify the chunk signature
+	if c.signed {
+		stringToSign := fmt.Sprintf(
+			"AWS4-HMAC-SHA256-PAYLOAD\n%s\n%s/%s/s3/aws4_request\n%s\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n%x",
+			c.timestamp,
+			c.date,
+			c.region,
+			c.lastSignature,
+			sha256.Sum256(chunk),
+		)
+
+		signature := hmacSHA256(c.signingKey, stringToSign)
+		if chunkSignature != fmt.Sprintf("%x", signature) {
+			return InvalidChunk
+		}
+		c.lastSignature = chunkSignature
+	}
+
+	// step 4: the final chunk is followed by trailing headers rather than
+	// a line break
+	if chunkLength == 0 {
+		if err := c.readTrailer(); err != nil {
+			return err
+		}
+		return c.finish()
+	}
+
 	trailer := make([]byte, 2)
 	_, err = io.ReadFull(c.bufBody, trailer)
 	if err != nil || trailer[0] != '\r' || trailer[1] != '\n' {
 		return InvalidChunk
 	}
 
-	// step 4: construct the string to sign
-	stringToSign := fmt.Sprintf(
-		"AWS4-HMAC-SHA256-PAYLOAD\n%s\n%s/%s/s3/aws4_request\n%s\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n%x",
-		c.timestamp,
-		c.date,
-		c.region,
-		c.lastSignature,
-		sha256.Sum256(chunk),
-	)
-
-	// step 5: calculate & verify the signature
-	signature := hmacSHA256(c.signingKey, stringToSign)
-	if chunkSignature != fmt.Sprintf("%x", signature) {
-		return InvalidChunk
+	c.length += int64(chunkLength)
+	if c.decodedLength >= 0 && c.length > c.decodedLength {
+		return InvalidChunkLength
+	}
+	if c.hash != nil {
+		c.hash.Write(chunk)
 	}
 
 	c.lastChunk = chunk
-	c.lastSignature = chunkSignature
 	return nil
 }
 
+// readTrailer reads the trailing headers that follow the final chunk, up to
+// and including the terminating empty line, and verifies their signature
+func (c *chunkedReader) readTrailer() error {
+	var trailers strings.Builder
+	trailerSignature := ""
+
+	for {
+		line, err := c.bufBody.ReadString('\n')
+		if err != nil && (err != io.EOF || line != "") {
+			return InvalidChunk
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// some clients (e.g. minio-go) send an empty line between the
+			// trailing headers and their signature
+			if c.signed && trailers.Len() > 0 && trailerSignature == "" && err == nil {
+				continue
+			}
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return InvalidChunk
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		if name == "x-amz-trailer-signature" {
+			trailerSignature = value
+			continue
+		}
+		if name == c.trailer {
+			c.checksum.Value = value
+		}
+		trailers.WriteString(name)
+		trailers.WriteString(":")
+		trailers.WriteString(value)
+		trailers.WriteString("\n")
+	}
+
+	if c.signed && trailers.Len() > 0 {
+		stringToSign := fmt.Sprintf(
+			"AWS4-HMAC-SHA256-TRAILER\n%s\n%s/%s/s3/aws4_request\n%s\n%x",
+			c.timestamp,
+			c.date,
+			c.region,
+			c.lastSignature,
+			sha256.Sum256([]byte(trailers.String())),
+		)
+
+		signature := hmacSHA256(c.signingKey, stringToSign)
+		if trailerSignature != fmt.Sprintf("%x", signature) {
+			return InvalidChunk
+		}
+	}
+
+	return nil
+}
+
+// finish verifies the decoded length and checksum once all chunks have been
+// read. It returns `io.EOF` if they're valid.
+func (c *chunkedReader) finish() error {
+	if c.decodedLength >= 0 && c.length != c.decodedLength {
+		return InvalidChunkLength
+	}
+	if c.checksum != nil {
+		if c.checksum.Value == "" {
+			return InvalidChunk
+		}
+		if base64.StdEncoding.EncodeToString(c.hash.Sum(nil)) != c.checksum.Value {
+			return InvalidChunkChecksum
+		}
+	}
+	return io.EOF
+}
+
 func (c *chunkedReader) Close() error {
 	return c.body.Close()
 }
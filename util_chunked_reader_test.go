@@ -0,0 +1,358 @@
+package s2
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testChunkTimestamp = "20130524T000000Z"
+	testChunkDate      = "20130524"
+	testChunkRegion    = "us-east-1"
+	testSeedSignature  = "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9"
+)
+
+var testSigningKey = []byte("signing key")
+
+// signedChunkedBody encodes chunks as a signed multi-chunk upload body,
+// followed by the given trailing headers
+func signedChunkedBody(chunks []string, trailers string) string {
+	var body strings.Builder
+	lastSignature := testSeedSignature
+	for _, chunk := range append(chunks, "") {
+		stringToSign := fmt.Sprintf(
+			"AWS4-HMAC-SHA256-PAYLOAD\n%s\n%s/%s/s3/aws4_request\n%s\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n%s",
+			testChunkTimestamp, testChunkDate, testChunkRegion, lastSignature, sha256Hex(chunk),
+		)
+		lastSignature = fmt.Sprintf("%x", hmacSHA256(testSigningKey, stringToSign))
+		fmt.Fprintf(&body, "%x;chunk-signature=%s\r\n%s", len(chunk), lastSignature, chunk)
+		if chunk != "" {
+			body.WriteString("\r\n")
+		}
+	}
+
+	if trailers != "" {
+		stringToSign := fmt.Sprintf(
+			"AWS4-HMAC-SHA256-TRAILER\n%s\n%s/%s/s3/aws4_request\n%s\n%s",
+			testChunkTimestamp, testChunkDate, testChunkRegion, lastSignature, sha256Hex(trailers),
+		)
+		body.WriteString(strings.Replace(trailers, "\n", "\r\n", -1))
+		fmt.Fprintf(&body, "x-amz-trailer-signature:%x\r\n", hmacSHA256(testSigningKey, stringToSign))
+	}
+	body.WriteString("\r\n")
+	return body.String()
+}
+
+// truncateBefore truncates a body before the first occurrence of `s`
+func truncateBefore(body, s string) string {
+	return body[:strings.Index(body, s)]
+}
+
+func sha256Hex(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+func TestChunkedBody(t *testing.T) {
+	crc := computeChecksum(ChecksumCRC32, []byte("hello world"))
+	badCRC := computeChecksum(ChecksumCRC32, []byte("goodbye"))
+
+	tests := []struct {
+		name    string
+		payload string
+		headers map[string]string
+		body    string
+		err     error
+	}{
+		{
+			name:    "signed",
+			payload: streamingPayload,
+			body:    signedChunkedBody([]string{"hello ", "world"}, ""),
+		},
+		{
+			name:    "signed with bad signature",
+			payload: streamingPayload,
+			body:    strings.Replace(signedChunkedBody([]string{"hello ", "world"}, ""), "world", "wOrld", 1),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed with trailer",
+			payload: streamingPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+crc+"\n"),
+		},
+		{
+			name:    "signed with bad trailer signature",
+			payload: streamingPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    strings.Replace(signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+badCRC+"\n"), badCRC, crc, 1),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed with trailer and empty line before signature",
+			payload: streamingPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    strings.Replace(signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+crc+"\n"), "\r\nx-amz-trailer-signature", "\n\r\nx-amz-trailer-signature", 1),
+		},
+		{
+			name:    "unsigned with trailer",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32", "x-amz-decoded-content-length": "11"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\nx-amz-checksum-crc32:" + crc + "\r\n\r\n",
+		},
+		{
+			name:    "unsigned with bad trailing checksum",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\nx-amz-checksum-crc32:" + badCRC + "\r\n\r\n",
+			err:     InvalidChunkChecksum,
+		},
+		{
+			name:    "unsigned with missing trailing checksum",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n",
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed truncated before the final chunk",
+			payload: streamingPayload,
+			body:    truncateBefore(signedChunkedBody([]string{"hello ", "world"}, ""), "0;chunk-signature"),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed truncated within a chunk",
+			payload: streamingPayload,
+			body:    truncateBefore(signedChunkedBody([]string{"hello ", "world"}, ""), "rld"),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed with trailer truncated before the trailer",
+			payload: streamingPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    truncateBefore(signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+crc+"\n"), "x-amz-checksum-crc32"),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "signed with trailer truncated before the trailer signature",
+			payload: streamingPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    truncateBefore(signedChunkedBody([]string{"hello ", "world"}, "x-amz-checksum-crc32:"+crc+"\n"), "x-amz-trailer-signature"),
+			err:     InvalidChunk,
+		},
+		{
+			name:    "unsigned truncated before the final chunk",
+			payload: streamingUnsignedPayloadTrailer,
+			body:    "6\r\nhello \r\n5\r\nworld\r\n",
+			err:     InvalidChunk,
+		},
+		{
+			name:    "unsigned with trailer truncated before the final chunk",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n",
+			err:     InvalidChunk,
+		},
+		{
+			name:    "unsigned with trailer truncated before the trailer",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\n",
+			err:     InvalidChunk,
+		},
+		{
+			name:    "empty",
+			payload: streamingPayload,
+			body:    "",
+			err:     InvalidChunk,
+		},
+		{
+			name:    "unsigned with bad decoded length",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-decoded-content-length": "12"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n",
+			err:     InvalidChunkLength,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader(test.body))
+			r.Header.Set("x-amz-content-sha256", test.payload)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			r = withContextValue(r, chunkSignerContextKey, &chunkSigner{
+				signingKey:    testSigningKey,
+				seedSignature: testSeedSignature,
+				timestamp:     testChunkTimestamp,
+				date:          testChunkDate,
+				region:        testChunkRegion,
+			})
+
+			body, checksum, err := chunkedBody(r, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			content, err := ioutil.ReadAll(body)
+			if err != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if string(content) != "hello world" {
+				t.Errorf("unexpected content: %q", content)
+			}
+			if test.headers["x-amz-trailer"] != "" && (checksum == nil || checksum.Value != crc) {
+				t.Errorf("unexpected checksum: %+v", checksum)
+			}
+		})
+	}
+}
+
+// chunkedTestObjectController is an `ObjectController` that records the
+// contents of the last object put, and whether its checksum was verified as
+// it was read
+type chunkedTestObjectController struct {
+	unimplementedObjectController
+	content        string
+	verifiedOnRead bool
+}
+
+func (c *chunkedTestObjectController) PutObject(r *http.Request, bucket, key string, reader io.Reader) (*PutObjectResult, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	c.content = string(content)
+	_, c.verifiedOnRead = reader.(*checksumReader)
+	return &PutObjectResult{}, nil
+}
+
+// TestUnhashedPayloads verifies that uploads whose `x-amz-content-sha256`
+// isn't a hash of the body are accepted end-to-end
+func TestUnhashedPayloads(t *testing.T) {
+	crc := computeChecksum(ChecksumCRC32, []byte("hello world"))
+
+	tests := []struct {
+		name    string
+		payload string
+		headers map[string]string
+		body    string
+	}{
+		{
+			name:    "unsigned",
+			payload: unsignedPayload,
+			body:    "hello world",
+		},
+		{
+			name:    "unsigned streaming with trailer",
+			payload: streamingUnsignedPayloadTrailer,
+			headers: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32", "x-amz-decoded-content-length": "11"},
+			body:    "6\r\nhello \r\n5\r\nworld\r\n0\r\nx-amz-checksum-crc32:" + crc + "\r\n\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := &chunkedTestObjectController{}
+			s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+			s.Object = controller
+
+			r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader(test.body))
+			r.Header.Set("Content-Length", strconv.Itoa(len(test.body)))
+			r.Header.Set("x-amz-content-sha256", test.payload)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+			if controller.content != "hello world" {
+				t.Errorf("unexpected content: %q", controller.content)
+			}
+		})
+	}
+}
+
+// TestChecksumWithoutContentLength verifies that the additional checksum of
+// an upload without a `Content-Length`, which isn't buffered, is verified
+// as it's read
+func TestChecksumWithoutContentLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		code     int
+	}{
+		{
+			name:     "matching",
+			checksum: computeChecksum(ChecksumCRC32, []byte("hello world")),
+			code:     http.StatusOK,
+		},
+		{
+			name:     "mismatched",
+			checksum: computeChecksum(ChecksumCRC32, []byte("goodbye world")),
+			code:     http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := &chunkedTestObjectController{}
+			s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+			s.Object = controller
+
+			r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader("hello world"))
+			r.ContentLength = -1
+			r.Header.Set("Transfer-Encoding", "chunked")
+			r.Header.Set("x-amz-checksum-crc32", test.checksum)
+			rec := httptest.NewRecorder()
+			s.Router().ServeHTTP(rec, r)
+
+			if rec.Code != test.code {
+				t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+			}
+			if test.code != http.StatusOK && !strings.Contains(rec.Body.String(), "BadDigest") {
+				t.Errorf("unexpected response: %s", rec.Body.String())
+			}
+			if test.code == http.StatusOK && !controller.verifiedOnRead {
+				t.Errorf("checksum wasn't verified as the body was read")
+			}
+		})
+	}
+}
+
+// TestChecksumWithContentLength verifies that the additional checksum of an
+// upload with a `Content-Length`, which is buffered and verified by
+// `bodyReadingMiddleware`, isn't verified a second time as it's read
+func TestChecksumWithContentLength(t *testing.T) {
+	controller := &chunkedTestObjectController{}
+	s := NewS2(logrus.NewEntry(logrus.New()), 0, 5*time.Second)
+	s.Object = controller
+
+	r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader("hello world"))
+	r.Header.Set("Content-Length", "11")
+	r.Header.Set("x-amz-checksum-crc32", computeChecksum(ChecksumCRC32, []byte("hello world")))
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+	if controller.verifiedOnRead {
+		t.Errorf("checksum was verified again as the body was read")
+	}
+}
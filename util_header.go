@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,14 +17,17 @@ import (
 const (
 	// awsTimeFormat specifies the time format used in AWS requests
 	awsTimeFormat = "20060102T150405Z"
-	// skewTime specifies the maximum delta between the current time and the
-	// time specified in the HTTP request
-	skewTime = 15 * time.Minute
+	// defaultClockSkew specifies the default maximum delta between the
+	// current time and the time specified in the HTTP request
+	defaultClockSkew = 15 * time.Minute
 )
 
 var (
 	// unixEpoch represents the unix epoch time (Jan 1 1970)
 	unixEpoch = time.Unix(0, 0)
+	// copySourceRangeValidator is a regex for validating the
+	// `x-amz-copy-source-range` header
+	copySourceRangeValidator = regexp.MustCompile(`^bytes=([0-9]+)-([0-9]+)$`)
 )
 
 // intFormValue extracts an int value from a request's form values, ensuring
@@ -111,15 +116,45 @@ func shouldEscape(c byte) bool {
 	return true
 }
 
-// normQuery normalizes query string values using AWS' technique
+// normQuery normalizes query string values using AWS' technique: every key
+// and value is URI-encoded, and the parameters are sorted by key, then by
+// value. Parameters without a value are encoded with an empty value.
 func normQuery(v url.Values) string {
-	queryString := v.Encode()
+	params := [][2]string{}
+	for key, values := range v {
+		for _, value := range values {
+			params = append(params, [2]string{encodePathFrag(key), encodePathFrag(value)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
 
-	// Go encodes a space as '+' but Amazon requires '%20'. Luckily any '+' in the
-	// original query string has been percent escaped so all '+' chars that are left
-	// were originally spaces.
+	var query strings.Builder
+	for i, param := range params {
+		if i > 0 {
+			query.WriteString("&")
+		}
+		query.WriteString(param[0])
+		query.WriteString("=")
+		query.WriteString(param[1])
+	}
+	return query.String()
+}
 
-	return strings.Replace(queryString, "+", "%20", -1)
+// normHeaderValues normalizes the values of a header using AWS' auth V4
+// technique: leading and trailing whitespace is trimmed, sequential
+// whitespace is collapsed into a single space, and multiple values are
+// joined with commas
+func normHeaderValues(values []string) string {
+	normValues := make([]string, len(values))
+	for i, value := range values {
+		normValues[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(normValues, ",")
 }
 
 // hmacSHA1 computes HMAC with SHA1
@@ -145,6 +180,106 @@ func requireContentLength(r *http.Request) error {
 	return nil
 }
 
+// copySourceHeader parses the `x-amz-copy-source` header of a copy request
+// into the source bucket, key and version
+func copySourceHeader(r *http.Request) (string, string, string, error) {
+	var srcBucket string
+	var srcKey string
+	srcURL, err := url.Parse(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return "", "", "", InvalidArgumentError(r)
+	}
+	srcPath := strings.SplitN(srcURL.Path, "/", 3)
+	if len(srcPath) == 2 {
+		srcBucket = srcPath[0]
+		srcKey = srcPath[1]
+	} else if len(srcPath) == 3 {
+		if srcPath[0] != "" {
+			return "", "", "", InvalidArgumentError(r)
+		}
+		srcBucket = srcPath[1]
+		srcKey = srcPath[2]
+	} else {
+		return "", "", "", InvalidArgumentError(r)
+	}
+	srcVersionID := srcURL.Query().Get("versionId")
+
+	if srcBucket == "" {
+		return "", "", "", InvalidBucketNameError(r)
+	}
+	if srcKey == "" {
+		return "", "", "", NoSuchKeyError(r)
+	}
+	return srcBucket, srcKey, srcVersionID, nil
+}
+
+// copySourceRangeHeader parses the `x-amz-copy-source-range` header of a
+// part copy request, which has the form `bytes=first-last`, into an offset
+// and length within an object of the given size. If the header is not set,
+// the range spans the entire object.
+func copySourceRangeHeader(r *http.Request, size uint64) (uint64, uint64, error) {
+	rangeStr := r.Header.Get("x-amz-copy-source-range")
+	if rangeStr == "" {
+		return 0, size, nil
+	}
+
+	match := copySourceRangeValidator.FindStringSubmatch(rangeStr)
+	if len(match) == 0 {
+		return 0, 0, InvalidArgumentError(r)
+	}
+	first, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return 0, 0, InvalidArgumentError(r)
+	}
+	last, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil || last < first {
+		return 0, 0, InvalidArgumentError(r)
+	}
+	if last >= size {
+		return 0, 0, InvalidRangeError(r)
+	}
+	return first, last - first + 1, nil
+}
+
+// objectAttributesFromRequest extracts the user-specified object attributes
+// from a request's headers
+func objectAttributesFromRequest(r *http.Request) (*ObjectAttributes, error) {
+	attrs := ObjectAttributes{
+		ContentType:          r.Header.Get("Content-Type"),
+		ContentEncoding:      r.Header.Get("Content-Encoding"),
+		ContentDisposition:   r.Header.Get("Content-Disposition"),
+		ContentLanguage:      r.Header.Get("Content-Language"),
+		CacheControl:         r.Header.Get("Cache-Control"),
+		Expires:              r.Header.Get("Expires"),
+		StorageClass:         r.Header.Get("x-amz-storage-class"),
+		Metadata:             map[string]string{},
+		Tags:                 map[string]string{},
+		ACL:                  r.Header.Get("x-amz-acl"),
+		ServerSideEncryption: r.Header.Get("x-amz-server-side-encryption"),
+		SSEKMSKeyID:          r.Header.Get("x-amz-server-side-encryption-aws-kms-key-id"),
+	}
+
+	for name, values := range r.Header {
+		canonicalName := http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(canonicalName, "X-Amz-Meta-") {
+			metadataName := strings.ToLower(strings.TrimPrefix(canonicalName, "X-Amz-Meta-"))
+			attrs.Metadata[metadataName] = strings.Join(values, ",")
+		}
+	}
+
+	if tagging := r.Header.Get("x-amz-tagging"); tagging != "" {
+		tags, err := url.ParseQuery(tagging)
+		if err != nil {
+			return nil, InvalidArgumentError(r)
+		}
+		for name := range tags {
+			attrs.Tags[name] = tags.Get(name)
+		}
+	}
+
+	return &attrs, nil
+}
+
 // singleHeader gets a single header value. This is used in places instead of
 // `r.Header.Get()` because it differentiates between missing headers versus
 // empty header values.
@@ -168,7 +303,8 @@ func formatAWSTimestamp(t time.Time) string {
 // 1) as AWS' custom format (e.g. 20060102T150405Z)
 // 2) as RFC1123
 // 3) as RFC1123Z
-func parseAWSTimestamp(r *http.Request) (time.Time, error) {
+// The timestamp must be within `skew` of the current time.
+func parseAWSTimestamp(r *http.Request, skew time.Duration) (time.Time, error) {
 	timestampStr := r.Header.Get("x-amz-date")
 	if timestampStr == "" {
 		timestampStr = r.Header.Get("date")
@@ -190,7 +326,7 @@ func parseAWSTimestamp(r *http.Request) (time.Time, error) {
 	}
 
 	now := time.Now()
-	if !timestamp.After(now.Add(-skewTime)) || timestamp.After(now.Add(skewTime)) {
+	if !timestamp.After(now.Add(-skew)) || timestamp.After(now.Add(skew)) {
 		return time.Time{}, RequestTimeTooSkewedError(r)
 	}
 
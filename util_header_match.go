@@ -11,6 +11,99 @@ import (
 	"time"
 )
 
+// checkGetConditions evaluates the standard conditional headers of a GET or
+// HEAD request against an object, using S3's precedence rules: a matching
+// `If-Match` overrides a failing `If-Unmodified-Since`, and a non-matching
+// `If-None-Match` overrides a failing `If-Modified-Since`. It returns a
+// `PreconditionFailed` error if the request should fail, or true if a
+// `304 Not Modified` response should be sent instead.
+func checkGetConditions(r *http.Request, etag string, modTime time.Time) (bool, error) {
+	etag = addETagQuotes(etag)
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !checkIfMatch(ifMatch, etag) {
+			return false, PreconditionFailedError(r)
+		}
+	} else if !checkIfUnmodifiedSince(r.Header.Get("If-Unmodified-Since"), modTime) {
+		return false, PreconditionFailedError(r)
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !checkIfNoneMatch(ifNoneMatch, etag) {
+			return true, nil
+		}
+	} else if !checkIfModifiedSince(r.Header.Get("If-Modified-Since"), modTime) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// stripGetConditions removes the standard conditional headers from a
+// request, so that they're not evaluated a second time (with non-S3
+// semantics) by `http.ServeContent`
+func stripGetConditions(r *http.Request) {
+	r.Header.Del("If-Match")
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	r.Header.Del("If-Unmodified-Since")
+}
+
+// writePreconditionFromRequest parses the conditional headers of a write
+// request. If no conditional headers are set, nil is returned.
+func writePreconditionFromRequest(r *http.Request) (*WritePrecondition, error) {
+	ifMatch := textproto.TrimString(r.Header.Get("If-Match"))
+	ifNoneMatch := textproto.TrimString(r.Header.Get("If-None-Match"))
+
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil, nil
+	}
+	if ifMatch != "" && ifNoneMatch != "" {
+		return nil, InvalidRequestError(r, "Cannot specify both If-Match and If-None-Match")
+	}
+	if ifNoneMatch != "" && ifNoneMatch != "*" {
+		// only `If-None-Match: *` is supported for writes
+		return nil, NotImplementedError(r)
+	}
+
+	return &WritePrecondition{
+		IfNoneMatch: ifNoneMatch == "*",
+		IfMatch:     ifMatch,
+	}, nil
+}
+
+// hasCopySourceConditions returns whether any copy source preconditions are
+// specified
+func hasCopySourceConditions(r *http.Request) bool {
+	for _, name := range []string{"x-amz-copy-source-if-match", "x-amz-copy-source-if-none-match", "x-amz-copy-source-if-unmodified-since", "x-amz-copy-source-if-modified-since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCopySourceConditions evaluates the `x-amz-copy-source-if-*` headers
+// of a copy request against the source object, returning a
+// `PreconditionFailed` error if any of them do not hold
+func checkCopySourceConditions(r *http.Request, etag string, modTime time.Time) error {
+	etag = addETagQuotes(etag)
+
+	if !checkIfMatch(r.Header.Get("x-amz-copy-source-if-match"), etag) {
+		return PreconditionFailedError(r)
+	}
+	if !checkIfNoneMatch(r.Header.Get("x-amz-copy-source-if-none-match"), etag) {
+		return PreconditionFailedError(r)
+	}
+	if !checkIfUnmodifiedSince(r.Header.Get("x-amz-copy-source-if-unmodified-since"), modTime) {
+		return PreconditionFailedError(r)
+	}
+	if !checkIfModifiedSince(r.Header.Get("x-amz-copy-source-if-modified-since"), modTime) {
+		return PreconditionFailedError(r)
+	}
+	return nil
+}
+
 func checkIfMatch(im string, etag string) bool {
 	if im == "" {
 		return true
@@ -55,6 +148,7 @@ func checkIfNoneMatch(inm string, etag string) bool {
 		}
 		if buf[0] == ',' {
 			buf = buf[1:]
+			continue
 		}
 		if buf[0] == '*' {
 			return false
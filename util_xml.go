@@ -13,6 +13,7 @@ import (
 // WriteError serializes an error to a response as XML
 func WriteError(logger *logrus.Entry, w http.ResponseWriter, r *http.Request, err error) {
 	s3Err := newGenericError(r, err)
+	recordErrorCode(r, s3Err.Code)
 	writeXML(logger, w, r, s3Err.HTTPStatus, s3Err)
 }
 